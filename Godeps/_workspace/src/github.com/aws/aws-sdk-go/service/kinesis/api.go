@@ -1117,11 +1117,12 @@ type GetShardIteratorInput struct {
 	//
 	//  AT_SEQUENCE_NUMBER - Start reading exactly from the position denoted by
 	// a specific sequence number. AFTER_SEQUENCE_NUMBER - Start reading right after
-	// the position denoted by a specific sequence number. TRIM_HORIZON - Start
-	// reading at the last untrimmed record in the shard in the system, which is
-	// the oldest data record in the shard. LATEST - Start reading just after the
-	// most recent record in the shard, so that you always read the most recent
-	// data in the shard.
+	// the position denoted by a specific sequence number. AT_TIMESTAMP - Start
+	// reading from the position denoted by a specific timestamp, provided in the
+	// value Timestamp. TRIM_HORIZON - Start reading at the last untrimmed record
+	// in the shard in the system, which is the oldest data record in the shard.
+	// LATEST - Start reading just after the most recent record in the shard, so
+	// that you always read the most recent data in the shard.
 	ShardIteratorType *string `type:"string" required:"true" enum:"ShardIteratorType"`
 
 	// The sequence number of the data record in the shard from which to start reading
@@ -1131,6 +1132,13 @@ type GetShardIteratorInput struct {
 	// The name of the stream.
 	StreamName *string `min:"1" type:"string" required:"true"`
 
+	// The timestamp of the data record from which to start reading. Used with
+	// shard iterator type AT_TIMESTAMP. If a record with this exact timestamp
+	// does not exist, the iterator returned is for the next (later) record. If
+	// the timestamp is older than the current trim horizon, the iterator returned
+	// is for the oldest untrimmed data record (TRIM_HORIZON).
+	Timestamp *time.Time `type:"timestamp" timestampFormat:"unix"`
+
 	metadataGetShardIteratorInput `json:"-" xml:"-"`
 }
 
@@ -1880,6 +1888,8 @@ const (
 	// @enum ShardIteratorType
 	ShardIteratorTypeAfterSequenceNumber = "AFTER_SEQUENCE_NUMBER"
 	// @enum ShardIteratorType
+	ShardIteratorTypeAtTimestamp = "AT_TIMESTAMP"
+	// @enum ShardIteratorType
 	ShardIteratorTypeTrimHorizon = "TRIM_HORIZON"
 	// @enum ShardIteratorType
 	ShardIteratorTypeLatest = "LATEST"
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const inProgressSuffix = ".inprogress"
+
+// archiveSink writes records into files under a directory, one open file per
+// partition (shard and/or arrival hour). Files are rotated once they reach a
+// size or age, and only after a closed file has been fsync'd (and compressed,
+// if requested) are its records acked.
+type archiveSink struct {
+	dir            string
+	byShard        bool
+	byHour         bool
+	rotateSize     int64
+	rotateInterval time.Duration
+	gzip           bool
+	formatter      recordFormatter
+	fail           func(error)
+
+	mu    sync.Mutex
+	files map[string]*archiveFile
+	count int
+}
+
+type archiveFile struct {
+	path    string
+	file    *os.File
+	size    int64
+	opened  time.Time
+	pending []*shardRecord
+}
+
+func newArchiveSink(opts Options, formatter recordFormatter, fail func(error)) *archiveSink {
+	s := &archiveSink{
+		dir:            opts.OutDir,
+		rotateSize:     opts.RotateSize,
+		rotateInterval: opts.RotateInterval,
+		gzip:           opts.Gzip,
+		formatter:      formatter,
+		fail:           fail,
+		files:          make(map[string]*archiveFile),
+	}
+	for _, part := range strings.Split(opts.OutPartition, ",") {
+		switch part {
+		case "shard":
+			s.byShard = true
+		case "hour":
+			s.byHour = true
+		}
+	}
+	go s.rotateExpired()
+	return s
+}
+
+// partition returns the directory, relative to dir, that record belongs in.
+func (s *archiveSink) partition(record *shardRecord) string {
+	var parts []string
+	if s.byShard {
+		parts = append(parts, record.ShardId)
+	}
+	if s.byHour {
+		arrival := time.Now()
+		if record.ApproximateArrivalTimestamp != nil {
+			arrival = *record.ApproximateArrivalTimestamp
+		}
+		parts = append(parts, arrival.UTC().Format("2006/01/02/15"))
+	}
+	return filepath.Join(parts...)
+}
+
+func (s *archiveSink) Write(record *shardRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	partition := s.partition(record)
+	f, ok := s.files[partition]
+	if !ok {
+		var err error
+		f, err = s.open(partition)
+		if err != nil {
+			return err
+		}
+		s.files[partition] = f
+	}
+	cw := &countingWriter{w: f.file}
+	if err := s.formatter.Format(cw, record); err != nil {
+		return err
+	}
+	f.size += cw.n
+	f.pending = append(f.pending, record)
+	if f.size >= s.rotateSize {
+		delete(s.files, partition)
+		return s.close(f)
+	}
+	return nil
+}
+
+func (s *archiveSink) open(partition string) (*archiveFile, error) {
+	dir := filepath.Join(s.dir, partition)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	s.count++
+	path := filepath.Join(dir, fmt.Sprintf("%s-%06d", now.UTC().Format("20060102T150405Z"), s.count))
+	file, err := os.OpenFile(path+inProgressSuffix, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &archiveFile{path: path, file: file, opened: now}, nil
+}
+
+// close syncs f, moves it to its final name and acks its records.
+func (s *archiveSink) close(f *archiveFile) error {
+	err := f.file.Sync()
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if s.gzip {
+		err = gzipFile(f.file.Name(), f.path+".gz")
+		if err == nil {
+			err = os.Remove(f.file.Name())
+		}
+	} else {
+		err = os.Rename(f.file.Name(), f.path)
+	}
+	if err != nil {
+		return err
+	}
+	for _, record := range f.pending {
+		record.Ack()
+	}
+	return nil
+}
+
+// gzipFile compresses src into dst and syncs dst.
+func gzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
+
+func (s *archiveSink) rotateExpired() {
+	tick := s.rotateInterval / 4
+	if tick > time.Minute {
+		tick = time.Minute
+	}
+	for now := range time.Tick(tick) {
+		s.mu.Lock()
+		for partition, f := range s.files {
+			if now.Sub(f.opened) < s.rotateInterval {
+				continue
+			}
+			delete(s.files, partition)
+			if err := s.close(f); err != nil {
+				s.mu.Unlock()
+				s.fail(fmt.Errorf("Could not rotate %s: %s", f.path, err))
+				return
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Close closes every open file so that their records are acked.
+func (s *archiveSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for partition, f := range s.files {
+		delete(s.files, partition)
+		if err := s.close(f); err != nil {
+			log.Printf("Could not close %s: %s", f.path, err)
+		}
+	}
+	return nil
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"compress/gzip"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchiveSinkRotatesAndAcks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "c2k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := Options{OutDir: dir, OutPartition: "shard,hour", RotateSize: 10, RotateInterval: time.Hour, Gzip: true}
+	s := newArchiveSink(opts, &rawFormatter{separator: separators["newline"]}, func(err error) { t.Error(err) })
+	arrival := time.Date(2026, 10, 18, 14, 5, 0, 0, time.UTC)
+	acked := 0
+	for _, data := range []string{"first", "second", "third"} {
+		err := s.Write(&shardRecord{ShardId: "shardId-0", Record: &kinesis.Record{
+			SequenceNumber: aws.String(data), Data: []byte(data), ApproximateArrivalTimestamp: &arrival,
+		}, ack: func() { acked++ }})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if acked != 2 {
+		t.Fatalf("Expected the two records of the rotated file to be acked but got %d", acked)
+	}
+	s.Close()
+	if acked != 3 {
+		t.Fatalf("Expected closing to ack the remaining record but got %d", acked)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "shardId-0", "2026", "10", "18", "14", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("Expected two archive files but got %v", files)
+	}
+	var contents []string
+	for _, name := range files {
+		if !strings.HasSuffix(name, ".gz") {
+			t.Errorf("Expected %s to be compressed", name)
+			continue
+		}
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(zr)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, string(data))
+	}
+	if strings.Join(contents, "|") != "first\nsecond\n|third\n" {
+		t.Errorf("Unexpected archive contents %q", contents)
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const manifestName = "manifest.json"
+
+// backupManifest describes a stream backup: the stream it was taken from and
+// every shard with its lineage and the file holding its records.
+type backupManifest struct {
+	StreamName string           `json:"streamName"`
+	StreamARN  string           `json:"streamARN"`
+	Region     string           `json:"region"`
+	CreatedAt  time.Time        `json:"createdAt"`
+	Shards     []*manifestShard `json:"shards"`
+}
+
+type manifestShard struct {
+	ShardId                string `json:"shardId"`
+	ParentShardId          string `json:"parentShardId,omitempty"`
+	AdjacentParentShardId  string `json:"adjacentParentShardId,omitempty"`
+	StartingHashKey        string `json:"startingHashKey"`
+	EndingHashKey          string `json:"endingHashKey"`
+	StartingSequenceNumber string `json:"startingSequenceNumber"`
+	EndingSequenceNumber   string `json:"endingSequenceNumber,omitempty"`
+	File                   string `json:"file"`
+	Records                int    `json:"records"`
+}
+
+func runBackup(cmd *command, args []string) {
+	fs := cmd.flagSet()
+	var opts Options
+	var dir string
+	addStreamFlags(fs, &opts)
+	fs.StringVar(&dir, "o", "", "Directory to write the backup to")
+	fs.Parse(args)
+	if opts.StreamName == "" || dir == "" {
+		log.Fatal("backup requires -s and -o")
+	}
+	svc := createService(opts)
+	manifest, err := backupStream(svc, opts, dir)
+	if err != nil {
+		log.Fatal("Backup failed: ", err)
+	}
+	total := 0
+	for _, shard := range manifest.Shards {
+		total += shard.Records
+	}
+	log.Printf("Backed up %d records from %d shards of %s to %s", total, len(manifest.Shards), opts.StreamName, dir)
+}
+
+// backupStream reads every retained record of every shard, from TRIM_HORIZON
+// until the shard is closed or caught up, into one JSON Lines file per shard.
+func backupStream(svc *kinesis.Kinesis, opts Options, dir string) (*backupManifest, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	manifest := &backupManifest{
+		StreamName: opts.StreamName,
+		StreamARN:  getStreamARN(svc, opts.StreamName),
+		Region:     opts.Region,
+		CreatedAt:  time.Now().UTC(),
+	}
+	shards := getShardIds(svc, opts.StreamName)
+	errs := make(chan error, len(shards))
+	var wg sync.WaitGroup
+	for _, shard := range shards {
+		ms := &manifestShard{
+			ShardId:                *shard.ShardId,
+			ParentShardId:          aws.StringValue(shard.ParentShardId),
+			AdjacentParentShardId:  aws.StringValue(shard.AdjacentParentShardId),
+			StartingHashKey:        *shard.HashKeyRange.StartingHashKey,
+			EndingHashKey:          *shard.HashKeyRange.EndingHashKey,
+			StartingSequenceNumber: *shard.SequenceNumberRange.StartingSequenceNumber,
+			EndingSequenceNumber:   aws.StringValue(shard.SequenceNumberRange.EndingSequenceNumber),
+			File:                   *shard.ShardId + ".jsonl",
+		}
+		manifest.Shards = append(manifest.Shards, ms)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := backupShard(svc, opts.StreamName, ms, filepath.Join(dir, ms.File)); err != nil {
+				errs <- fmt.Errorf("%s: %s", ms.ShardId, err)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	// The manifest is written last, so a directory without one is an
+	// incomplete backup.
+	return manifest, ioutil.WriteFile(filepath.Join(dir, manifestName), data, 0644)
+}
+
+func backupShard(svc *kinesis.Kinesis, streamName string, ms *manifestShard, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	trimHorizon := TrimHorizon
+	itr, err := svc.GetShardIterator(&kinesis.GetShardIteratorInput{ShardIteratorType: &trimHorizon, ShardId: &ms.ShardId, StreamName: &streamName})
+	if err != nil {
+		return err
+	}
+	formatter := &jsonlFormatter{}
+	limiter := limiterFor(streamName, ms.ShardId)
+	shardIterator := itr.ShardIterator
+	for shardIterator != nil {
+		limiter.wait()
+		out, err := svc.GetRecords(&kinesis.GetRecordsInput{ShardIterator: shardIterator})
+		if isThroughputExceeded(err) {
+			time.Sleep(time.Second)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		size := 0
+		for _, record := range out.Records {
+			if err := formatter.Format(w, &shardRecord{ShardId: ms.ShardId, Record: record}); err != nil {
+				return err
+			}
+			size += len(record.Data)
+		}
+		limiter.consumed(size, time.Now())
+		ms.Records += len(out.Records)
+		if len(out.Records) == 0 && aws.Int64Value(out.MillisBehindLatest) == 0 {
+			break
+		}
+		shardIterator = out.NextShardIterator
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func runRestore(cmd *command, args []string) {
+	fs := cmd.flagSet()
+	var opts Options
+	var recordRate, byteRate int
+	addStreamFlags(fs, &opts)
+	fs.IntVar(&recordRate, "rate", 1000, "Maximum records put per second, 0 for no limit")
+	fs.IntVar(&byteRate, "rate-bytes", 1024*1024, "Maximum bytes put per second, 0 for no limit")
+	fs.Parse(args)
+	if opts.StreamName == "" || fs.NArg() != 1 {
+		log.Fatal("restore requires -s and a backup directory")
+	}
+	dir := fs.Arg(0)
+	manifest, err := readManifest(dir)
+	if err != nil {
+		log.Fatal("Could not read backup: ", err)
+	}
+	svc := createService(opts)
+	t := &throttle{recordsPerSecond: recordRate, bytesPerSecond: byteRate}
+	total := 0
+	for _, ms := range lineageOrder(manifest.Shards) {
+		restored := 0
+		err := readBackupShard(filepath.Join(dir, ms.File), func(entries []*kinesis.PutRecordsRequestEntry) error {
+			if err := putInKeyOrder(svc, opts.StreamName, entries, t); err != nil {
+				return err
+			}
+			restored += len(entries)
+			return nil
+		})
+		total += restored
+		if err != nil {
+			log.Fatalf("Restoring %s failed after %d records: %s", ms.ShardId, total, err)
+		}
+		log.Printf("Restored %d records of %s", restored, ms.ShardId)
+	}
+	log.Printf("Restored %d records from %s into %s", total, manifest.StreamName, opts.StreamName)
+}
+
+func readManifest(dir string) (*backupManifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, manifestName))
+	if err != nil {
+		return nil, err
+	}
+	var manifest backupManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// lineageOrder sorts shards so that parents always come before their
+// children. A partition key lives in one shard at a time, so restoring the
+// shards in this order keeps each key's records in order.
+func lineageOrder(shards []*manifestShard) []*manifestShard {
+	byId := make(map[string]*manifestShard)
+	for _, ms := range shards {
+		byId[ms.ShardId] = ms
+	}
+	var ordered []*manifestShard
+	visited := make(map[string]bool)
+	var visit func(ms *manifestShard)
+	visit = func(ms *manifestShard) {
+		if visited[ms.ShardId] {
+			return
+		}
+		visited[ms.ShardId] = true
+		for _, parentId := range []string{ms.ParentShardId, ms.AdjacentParentShardId} {
+			if parent, ok := byId[parentId]; ok {
+				visit(parent)
+			}
+		}
+		ordered = append(ordered, ms)
+	}
+	for _, ms := range shards {
+		visit(ms)
+	}
+	return ordered
+}
+
+// restoreChunkSize is how many records are read from a backup file at a time.
+const restoreChunkSize = 10000
+
+// readBackupShard reads the records of a backup file in order, handing them
+// to fn in chunks so that large shards need not fit in memory.
+func readBackupShard(path string, fn func([]*kinesis.PutRecordsRequestEntry) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var entries []*kinesis.PutRecordsRequestEntry
+	dec := json.NewDecoder(bufio.NewReader(f))
+	for dec.More() {
+		var jr jsonRecord
+		if err := dec.Decode(&jr); err != nil {
+			return err
+		}
+		data := []byte(jr.Data)
+		if jr.DataEncoding == "base64" {
+			if data, err = base64.StdEncoding.DecodeString(jr.Data); err != nil {
+				return err
+			}
+		}
+		entries = append(entries, &kinesis.PutRecordsRequestEntry{Data: data, PartitionKey: aws.String(jr.PartitionKey)})
+		if len(entries) == restoreChunkSize {
+			if err := fn(entries); err != nil {
+				return err
+			}
+			entries = nil
+		}
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	return fn(entries)
+}
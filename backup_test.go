@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLineageOrder(t *testing.T) {
+	shards := []*manifestShard{
+		{ShardId: "shardId-3", ParentShardId: "shardId-1", AdjacentParentShardId: "shardId-2"},
+		{ShardId: "shardId-2", ParentShardId: "shardId-0"},
+		{ShardId: "shardId-1", ParentShardId: "shardId-0"},
+		{ShardId: "shardId-0"},
+	}
+	position := make(map[string]int)
+	for i, ms := range lineageOrder(shards) {
+		position[ms.ShardId] = i
+	}
+	if len(position) != 4 {
+		t.Fatalf("Expected every shard once but got %v", position)
+	}
+	for _, ms := range shards {
+		for _, parent := range []string{ms.ParentShardId, ms.AdjacentParentShardId} {
+			if parent != "" && position[parent] > position[ms.ShardId] {
+				t.Errorf("%s restored before its parent %s", ms.ShardId, parent)
+			}
+		}
+	}
+}
+
+func TestBackupFileRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "c2k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "shardId-0.jsonl")
+
+	records := []*kinesis.Record{
+		{SequenceNumber: aws.String("1"), PartitionKey: aws.String("a"), Data: []byte("text")},
+		{SequenceNumber: aws.String("2"), PartitionKey: aws.String("b"), Data: []byte{0xff, 0, 0xfe}},
+	}
+	var buf bytes.Buffer
+	for _, record := range records {
+		if err := (&jsonlFormatter{}).Format(&buf, &shardRecord{ShardId: "shardId-0", Record: record}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var restored []*kinesis.PutRecordsRequestEntry
+	err = readBackupShard(path, func(entries []*kinesis.PutRecordsRequestEntry) error {
+		restored = append(restored, entries...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) != len(records) {
+		t.Fatalf("Expected %d records but got %d", len(records), len(restored))
+	}
+	for i, entry := range restored {
+		if !bytes.Equal(entry.Data, records[i].Data) || *entry.PartitionKey != *records[i].PartitionKey {
+			t.Errorf("Record %d did not survive the round trip", i)
+		}
+	}
+}
+
+func TestKeyOrderedBatches(t *testing.T) {
+	var entries []*kinesis.PutRecordsRequestEntry
+	for _, key := range []string{"a", "b", "a", "c", "b", "d"} {
+		entries = append(entries, &kinesis.PutRecordsRequestEntry{PartitionKey: aws.String(key), Data: []byte(key)})
+	}
+	batches := keyOrderedBatches(entries)
+	expected := []string{"ab", "acbd"}
+	if len(batches) != len(expected) {
+		t.Fatalf("Expected %d batches but got %d", len(expected), len(batches))
+	}
+	for i, batch := range batches {
+		keys := ""
+		for _, entry := range batch {
+			keys += *entry.PartitionKey
+		}
+		if keys != expected[i] {
+			t.Errorf("Batch %d: expected keys %s but got %s", i, expected[i], keys)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// shardBatcher groups records from the same shard into batches of up to size
+// records. A batch is handed to flush once it is full, or once its first
+// record has waited for window.
+type shardBatcher struct {
+	size   int
+	window time.Duration
+	flush  func([]*shardRecord)
+
+	mu      sync.Mutex
+	batches map[string]*recordBatch
+}
+
+type recordBatch struct {
+	records []*shardRecord
+	started time.Time
+}
+
+func newShardBatcher(size int, window time.Duration, flush func([]*shardRecord)) *shardBatcher {
+	b := &shardBatcher{size: size, window: window, flush: flush, batches: make(map[string]*recordBatch)}
+	if size > 1 {
+		go b.flushExpired()
+	}
+	return b
+}
+
+func (b *shardBatcher) add(record *shardRecord) {
+	b.mu.Lock()
+	batch, ok := b.batches[record.ShardId]
+	if !ok {
+		batch = &recordBatch{started: time.Now()}
+		b.batches[record.ShardId] = batch
+	}
+	batch.records = append(batch.records, record)
+	full := len(batch.records) >= b.size
+	if full {
+		delete(b.batches, record.ShardId)
+	}
+	b.mu.Unlock()
+	if full {
+		b.flush(batch.records)
+	}
+}
+
+func (b *shardBatcher) flushExpired() {
+	for now := range time.Tick(b.window / 2) {
+		var expired [][]*shardRecord
+		b.mu.Lock()
+		for shardId, batch := range b.batches {
+			if now.Sub(batch.started) >= b.window {
+				expired = append(expired, batch.records)
+				delete(b.batches, shardId)
+			}
+		}
+		b.mu.Unlock()
+		for _, records := range expired {
+			b.flush(records)
+		}
+	}
+}
+
+// shardQueues hands batches to handle one at a time per shard, so a shard's
+// batches are handled in order while different shards proceed concurrently.
+type shardQueues struct {
+	handle func([]*shardRecord)
+
+	mu     sync.Mutex
+	queues map[string]chan []*shardRecord
+}
+
+func newShardQueues(handle func([]*shardRecord)) *shardQueues {
+	return &shardQueues{handle: handle, queues: make(map[string]chan []*shardRecord)}
+}
+
+// enqueue hands records to their shard's worker, starting one if needed.
+func (q *shardQueues) enqueue(records []*shardRecord) {
+	shardId := records[0].ShardId
+	q.mu.Lock()
+	queue, ok := q.queues[shardId]
+	if !ok {
+		queue = make(chan []*shardRecord, 1)
+		q.queues[shardId] = queue
+		go q.work(queue)
+	}
+	q.mu.Unlock()
+	queue <- records
+}
+
+func (q *shardQueues) work(queue <-chan []*shardRecord) {
+	for records := range queue {
+		q.handle(records)
+	}
+}
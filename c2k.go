@@ -4,12 +4,14 @@ import (
 	"bufio"
 	"flag"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/service/firehose"
 	"github.com/aws/aws-sdk-go/service/kinesis"
 	"io"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
@@ -19,89 +21,352 @@ const (
 	listenUsage                = "Listen to stream instead of sending data"
 	defaultProfile             = "default"
 	profileUsage               = "AWS Profile name to use for authentication"
-	defaultPartitionKey        = "1"
-	partitionKeyUsage          = "Partition key"
+	partitionKeyUsage          = "Partition key of the records: random, hash of the data, or a fixed key"
 	defaultRegion              = "us-east-1"
 	regionUsage                = "AWS region, defaults to us-east-1"
+	roleARNUsage               = "ARN of an IAM role to assume with the credentials found"
+	externalIDUsage            = "External ID to pass when assuming -role-arn"
+	mfaSerialUsage             = "Serial number or ARN of the MFA device to prompt a code for when assuming -role-arn"
+	endpointEnv                = "C2K_ENDPOINT"
+	endpointUsage              = "URL to send Kinesis requests to instead of AWS, e.g. a local emulator (env " + endpointEnv + ")"
+	firehoseEndpointEnv        = "C2K_FIREHOSE_ENDPOINT"
+	firehoseURLUsage           = "URL to send Firehose requests to, defaults to -endpoint (env " + firehoseEndpointEnv + ")"
+	noSSLEnv                   = "C2K_NO_SSL"
+	noSSLUsage                 = "Use plain HTTP for endpoints given without a scheme and for AWS (env " + noSSLEnv + ")"
 	startingSeqNumUsage        = "Sequence number to use for iterators that use a sequence number"
 	shardIdUsage               = "Shard ID for listen purposes"
 	defaultShardId      string = "ALL"
-	streamNameUsage            = "Stream name to put data"
+	streamNameUsage            = "Kinesis stream name"
+	sinceUsage                 = "Start listening at records that arrived this long ago, e.g. 2h"
+	fromUsage                  = "Start listening at records that arrived at or after this RFC3339 time"
+	outputUsage                = "Listen output format. Valid choices: lines, raw, jsonl (default lines, or raw with -exec)"
+	separatorUsage             = "Separator written after each record in raw output. Valid choices: none, newline, nul"
+	formatUsage                = "Go text/template used to write each listened record, overrides output"
+	mergeOrderUsage            = "Order of listened records across shards. Valid choices: none, arrival (to the second)"
+	maxSkewUsage               = "Longest a record is held back waiting for other shards when merging by arrival"
+	minPollUsage               = "Shortest wait between GetRecords calls on a shard that is caught up"
+	maxPollUsage               = "Longest wait between GetRecords calls on a shard with no new records"
+	lagReportUsage             = "Interval at which to print per-shard lag to stderr, e.g. 10s"
+	matchUsage                 = "Only listen to records whose data matches this regular expression"
+	keyFilterUsage             = "Only listen to records with this partition key"
+	whereUsage                 = "Only listen to JSON records matching this predicate, e.g. '.level == \"ERROR\"' (repeatable)"
+	forKeyUsage                = "Only listen to the shards owning this partition key and to records with this key"
+	checkpointUsage            = "File recording the last handled sequence number per shard; listening resumes after it"
+	execUsage                  = "Shell command to run for every listened record or batch, with records on stdin"
+	execBatchUsage             = "Number of records from a shard to pass to each exec command"
+	batchWindowUsage           = "Longest to wait for an exec batch to fill before running it anyway"
+	concurrencyUsage           = "Number of exec commands allowed to run at once"
+	lambdaURLUsage             = "Runtime Interface Emulator URL to POST Lambda Kinesis events to"
+	lambdaExecUsage            = "Shell command to pipe each Lambda Kinesis event to"
+	lambdaBatchUsage           = "Maximum number of records in each Lambda event"
+	lambdaWindowUsage          = "Longest to wait for a Lambda batch to fill before invoking anyway"
+	lambdaRetriesUsage         = "Times to retry a failed Lambda batch before dropping it, -1 to retry until it succeeds"
+	outDirUsage                = "Directory to archive listened records into instead of writing them to stdout"
+	outPartitionUsage          = "How to partition archive files. Valid choices: shard, hour, shard,hour"
+	rotateSizeUsage            = "Size in bytes at which an archive file is closed"
+	rotateIntervalUsage        = "Age at which an archive file is closed"
+	gzipUsage                  = "Gzip archive files once they are closed"
 	incompleteRead             = "c2k: incomplete read of stream"
 	noSuchFile                 = "c2k: %s: no such file"
 	TrimHorizon         string = "TRIM_HORIZON"
 	AtSequenceNum       string = "AT_SEQUENCE_NUMBER"
 	AfterSequenceNum    string = "AFTER_SEQUENCE_NUMBER"
 	Latest              string = "LATEST"
+	AtTimestamp         string = "AT_TIMESTAMP"
 )
 
 type Options struct {
 	Delimiter, Profile, Region, ShardId, StartingSeqNum, StreamName, PartitionKey, ItrType string
-	Firehose                                                                               bool
+	RoleARN, ExternalID, MFASerial, Endpoint, FirehoseEndpoint                             string
+	Output, Separator, Format, MergeOrder, Match, FilterPartitionKey, ForKey               string
+	Checkpoint, Exec, LambdaURL, LambdaExec, OutDir, OutPartition                          string
+	Where                                                                                  stringList
+	MaxSkew, MinPoll, MaxPoll, LagReport, ExecBatchWindow, LambdaBatchWindow               time.Duration
+	RotateInterval                                                                         time.Duration
+	RotateSize                                                                             int64
+	ExecBatch, ExecConcurrency, LambdaBatch, LambdaRetries                                 int
+	Firehose, Gzip, NoSSL                                                                  bool
+	StartingTimestamp                                                                      time.Time
 }
 
 func main() {
-	var listen bool
-	opts := parseArgs(&listen)
+	args := os.Args[1:]
+	if len(args) > 0 && strings.HasPrefix(args[0], "-") && !isHelp(args[0]) {
+		runDeprecated(args)
+		return
+	}
+	dispatch("c2k", commands, args)
+}
 
-	svc := createService(opts.Profile, opts.Region)
-	fsvc := createFirehoseService(opts.Profile, opts.Region)
+// runDeprecated keeps the flags c2k took before it had commands working:
+// -l listens to the stream, otherwise the files or stdin are put into it.
+func runDeprecated(args []string) {
+	log.Print("c2k: running without a command is deprecated, use c2k put or c2k get")
+	var listen bool
+	var since time.Duration
+	var from string
+	opts := Options{}
+	flag.BoolVar(&listen, "listen", false, listenUsage)
+	flag.BoolVar(&listen, "l", false, listenUsage+" (short)")
+	flag.BoolVar(&opts.Firehose, "f", false, "Firehose mode")
+	addStreamFlags(flag.CommandLine, &opts)
+	addPutFlags(flag.CommandLine, &opts)
+	addGetFlags(flag.CommandLine, &opts, &since, &from)
+	flag.CommandLine.Parse(args)
+	requireStreamName(opts)
 	if listen {
-		listener := NewListener(opts, svc)
-		listener.Listen(os.Stdout)
+		validateGetOptions(&opts, since, from)
+		getRecords(opts)
 	} else {
-		if len(flag.Args()) == 0 {
-			uploadFile("-", opts, svc, fsvc)
+		putFiles(opts, flag.Args())
+	}
+}
 
-		} else {
-			for _, fileName := range flag.Args() {
-				uploadFile(fileName, opts, svc, fsvc)
-			}
-		}
+func runPut(cmd *command, args []string) {
+	fs := cmd.flagSet()
+	opts := Options{}
+	fs.BoolVar(&opts.Firehose, "f", selectedTarget.Firehose, "Put into a Firehose delivery stream, same as c2k firehose put")
+	addStreamFlags(fs, &opts)
+	addPutFlags(fs, &opts)
+	fs.Parse(args)
+	requireStreamName(opts)
+	putFiles(opts, fs.Args())
+}
+
+func runFirehosePut(cmd *command, args []string) {
+	fs := cmd.flagSet()
+	opts := Options{Firehose: true}
+	addStreamFlags(fs, &opts)
+	addPutFlags(fs, &opts)
+	fs.Parse(args)
+	requireStreamName(opts)
+	putFiles(opts, fs.Args())
+}
+
+func runGet(cmd *command, args []string) {
+	fs := cmd.flagSet()
+	opts := Options{}
+	var since time.Duration
+	var from string
+	addStreamFlags(fs, &opts)
+	addGetFlags(fs, &opts, &since, &from)
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		log.Fatal("get takes no arguments, got ", fs.Args())
+	}
+	requireStreamName(opts)
+	validateGetOptions(&opts, since, from)
+	getRecords(opts)
+}
 
+// putFiles puts each line of files into the stream, reading stdin when there
+// are no files.
+func putFiles(opts Options, files []string) {
+	if n := len(opts.PartitionKey); !opts.Firehose && (n < 1 || n > 256) {
+		log.Fatal("partitionKey must be 1 to 256 characters long")
+	}
+	svc := createService(opts)
+	fsvc := createFirehoseService(opts)
+	if len(files) == 0 {
+		uploadFile("-", opts, svc, fsvc)
 	}
+	for _, fileName := range files {
+		uploadFile(fileName, opts, svc, fsvc)
+	}
+}
 
+func getRecords(opts Options) {
+	listener := NewListener(opts, createService(opts))
+	listener.Listen(os.Stdout)
 }
 
-func createService(profile, region string) *kinesis.Kinesis {
-	creds := credentials.NewSharedCredentials("", profile)
-	return kinesis.New(&aws.Config{Region: aws.String(region), Credentials: creds})
+func createService(opts Options) *kinesis.Kinesis {
+	return kinesis.New(awsConfig(opts, opts.Endpoint))
 }
 
-func createFirehoseService(profile, region string) *firehose.Firehose {
-	creds := credentials.NewSharedCredentials("", profile)
-	return firehose.New(&aws.Config{Region: aws.String(region), Credentials: creds})
+// createFirehoseService returns a Firehose client, sent to -endpoint when
+// -firehose-endpoint is not set since emulators such as localstack serve both.
+func createFirehoseService(opts Options) *firehose.Firehose {
+	endpoint := opts.FirehoseEndpoint
+	if endpoint == "" {
+		endpoint = opts.Endpoint
+	}
+	return firehose.New(awsConfig(opts, endpoint))
 }
 
-func parseArgs(listen *bool) Options {
-	opts := Options{}
-	flag.StringVar(&opts.Delimiter, "delimiter", defaultDelimiter, delimiterUsage)
-	flag.StringVar(&opts.Delimiter, "d", defaultDelimiter, delimiterUsage+" (short)")
-	flag.BoolVar(&opts.Firehose, "f", false, "Firehose mode")
-	flag.StringVar(&opts.ItrType, "iter", TrimHorizon, ItrUsage)
-	flag.StringVar(&opts.ItrType, "i", TrimHorizon, ItrUsage+" (short)")
-	flag.BoolVar(listen, "listen", false, listenUsage)
-	flag.BoolVar(listen, "l", false, listenUsage+" (short)")
-	flag.StringVar(&opts.PartitionKey, "partitionKey", defaultPartitionKey, partitionKeyUsage)
-	flag.StringVar(&opts.PartitionKey, "pk", defaultPartitionKey, partitionKeyUsage+" (short)")
-	flag.StringVar(&opts.Profile, "profile", defaultProfile, profileUsage)
-	flag.StringVar(&opts.Profile, "p", defaultProfile, profileUsage+" (short)")
-	flag.StringVar(&opts.Region, "region", defaultRegion, regionUsage)
-	flag.StringVar(&opts.Region, "r", defaultRegion, regionUsage+" (short)")
-	flag.StringVar(&opts.StartingSeqNum, "startingSeqNum", "", startingSeqNumUsage)
-	flag.StringVar(&opts.StartingSeqNum, "sn", "", startingSeqNumUsage+" (short)")
-	flag.StringVar(&opts.ShardId, "shardId", defaultShardId, shardIdUsage)
-	flag.StringVar(&opts.ShardId, "sId", defaultShardId, shardIdUsage+" (short)")
-	flag.StringVar(&opts.StreamName, "streamName", "", streamNameUsage)
-	flag.StringVar(&opts.StreamName, "s", "", streamNameUsage+" (short)")
-	flag.Parse()
+func awsConfig(opts Options, endpoint string) *aws.Config {
+	config := &aws.Config{Region: aws.String(opts.Region), Credentials: credentialsFor(opts), DisableSSL: aws.Bool(opts.NoSSL)}
+	if endpoint != "" {
+		config.Endpoint = aws.String(endpoint)
+	}
+	return config
+}
+
+func envBool(key string) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("Invalid %s %q: %s", key, value, err)
+	}
+	return b
+}
+
+// addAWSFlags registers the flags choosing the credentials and region.
+func addAWSFlags(fs *flag.FlagSet, opts *Options) {
+	profile := profileSetting()
+	region := setting([]string{"AWS_REGION", "AWS_DEFAULT_REGION"}, selectedTarget.Region, defaultRegion)
+	fs.StringVar(&opts.Profile, "profile", profile, profileUsage)
+	fs.StringVar(&opts.Profile, "p", profile, profileUsage+" (short)")
+	fs.StringVar(&opts.Region, "region", region, regionUsage)
+	fs.StringVar(&opts.Region, "r", region, regionUsage+" (short)")
+	fs.StringVar(&opts.RoleARN, "role-arn", "", roleARNUsage)
+	fs.StringVar(&opts.ExternalID, "external-id", "", externalIDUsage)
+	fs.StringVar(&opts.MFASerial, "mfa-serial", "", mfaSerialUsage)
+	fs.StringVar(&opts.Endpoint, "endpoint", setting([]string{endpointEnv}, selectedTarget.Endpoint, ""), endpointUsage)
+	fs.StringVar(&opts.FirehoseEndpoint, "firehose-endpoint", setting([]string{firehoseEndpointEnv}, selectedTarget.FirehoseEndpoint, ""), firehoseURLUsage)
+	fs.BoolVar(&opts.NoSSL, "no-ssl", noSSLSetting(), noSSLUsage)
+}
+
+// addStreamFlags registers the flags naming a stream and how to reach it.
+func addStreamFlags(fs *flag.FlagSet, opts *Options) {
+	addAWSFlags(fs, opts)
+	fs.StringVar(&opts.StreamName, "streamName", selectedTarget.Stream, streamNameUsage)
+	fs.StringVar(&opts.StreamName, "s", selectedTarget.Stream, streamNameUsage+" (short)")
+}
+
+func addPutFlags(fs *flag.FlagSet, opts *Options) {
+	fs.StringVar(&opts.Delimiter, "delimiter", defaultDelimiter, delimiterUsage)
+	fs.StringVar(&opts.Delimiter, "d", defaultDelimiter, delimiterUsage+" (short)")
+	partitionKey := setting(nil, selectedTarget.PartitionKey, randomPartitionKey)
+	fs.StringVar(&opts.PartitionKey, "partitionKey", partitionKey, partitionKeyUsage)
+	fs.StringVar(&opts.PartitionKey, "pk", partitionKey, partitionKeyUsage+" (short)")
+}
+
+func addGetFlags(fs *flag.FlagSet, opts *Options, since *time.Duration, from *string) {
+	fs.StringVar(&opts.ItrType, "iter", TrimHorizon, ItrUsage)
+	fs.StringVar(&opts.ItrType, "i", TrimHorizon, ItrUsage+" (short)")
+	fs.StringVar(&opts.StartingSeqNum, "startingSeqNum", "", startingSeqNumUsage)
+	fs.StringVar(&opts.StartingSeqNum, "sn", "", startingSeqNumUsage+" (short)")
+	fs.StringVar(&opts.ShardId, "shardId", defaultShardId, shardIdUsage)
+	fs.StringVar(&opts.ShardId, "sId", defaultShardId, shardIdUsage+" (short)")
+	fs.DurationVar(since, "since", 0, sinceUsage)
+	fs.StringVar(from, "from", "", fromUsage)
+	fs.StringVar(&opts.Output, "output", setting(nil, selectedTarget.Output, ""), outputUsage)
+	fs.StringVar(&opts.Separator, "separator", "newline", separatorUsage)
+	fs.StringVar(&opts.Format, "format", "", formatUsage)
+	fs.StringVar(&opts.MergeOrder, "merge-order", "none", mergeOrderUsage)
+	fs.DurationVar(&opts.MaxSkew, "max-skew", 5*time.Second, maxSkewUsage)
+	fs.DurationVar(&opts.MinPoll, "min-poll", 200*time.Millisecond, minPollUsage)
+	fs.DurationVar(&opts.MaxPoll, "max-poll", 5*time.Second, maxPollUsage)
+	fs.DurationVar(&opts.LagReport, "lag-report", 0, lagReportUsage)
+	fs.StringVar(&opts.Match, "match", "", matchUsage)
+	fs.StringVar(&opts.FilterPartitionKey, "partition-key", "", keyFilterUsage)
+	fs.Var(&opts.Where, "where", whereUsage)
+	fs.StringVar(&opts.ForKey, "for-key", "", forKeyUsage)
+	fs.StringVar(&opts.Checkpoint, "checkpoint", "", checkpointUsage)
+	fs.StringVar(&opts.Exec, "exec", "", execUsage)
+	fs.IntVar(&opts.ExecBatch, "exec-batch", 1, execBatchUsage)
+	fs.DurationVar(&opts.ExecBatchWindow, "exec-batch-window", time.Second, batchWindowUsage)
+	fs.IntVar(&opts.ExecConcurrency, "exec-concurrency", 1, concurrencyUsage)
+	fs.StringVar(&opts.LambdaURL, "lambda-url", "", lambdaURLUsage)
+	fs.StringVar(&opts.LambdaExec, "lambda-exec", "", lambdaExecUsage)
+	fs.IntVar(&opts.LambdaBatch, "lambda-batch", 100, lambdaBatchUsage)
+	fs.DurationVar(&opts.LambdaBatchWindow, "lambda-batch-window", 500*time.Millisecond, lambdaWindowUsage)
+	fs.IntVar(&opts.LambdaRetries, "lambda-retries", -1, lambdaRetriesUsage)
+	fs.StringVar(&opts.OutDir, "out-dir", "", outDirUsage)
+	fs.StringVar(&opts.OutPartition, "out-partition", "shard", outPartitionUsage)
+	fs.Int64Var(&opts.RotateSize, "rotate-size", 64*1024*1024, rotateSizeUsage)
+	fs.DurationVar(&opts.RotateInterval, "rotate-interval", time.Hour, rotateIntervalUsage)
+	fs.BoolVar(&opts.Gzip, "gzip", false, gzipUsage)
+}
+
+func requireStreamName(opts Options) {
 	if opts.StreamName == "" {
 		log.Fatal("streamName is a required parameter")
 	}
-	if *listen && opts.ItrType != TrimHorizon && opts.ItrType != Latest && opts.ItrType != AfterSequenceNum && opts.ItrType != AtSequenceNum {
+}
+
+// validateGetOptions checks the flags registered by addGetFlags and works out
+// the starting timestamp from since or from.
+func validateGetOptions(opts *Options, since time.Duration, from string) {
+	if opts.ItrType != TrimHorizon && opts.ItrType != Latest && opts.ItrType != AfterSequenceNum && opts.ItrType != AtSequenceNum {
 		log.Fatal("Invalid iter type given ", opts.ItrType)
 	}
-	return opts
+	if opts.Output == "" {
+		// A command is handed the records themselves unless an output
+		// format was asked for.
+		opts.Output = OutputLines
+		if opts.Exec != "" {
+			opts.Output = OutputRaw
+		}
+	}
+	if opts.Output != OutputLines && opts.Output != OutputRaw && opts.Output != OutputJSONL {
+		log.Fatal("Invalid output given ", opts.Output)
+	}
+	if _, ok := separators[opts.Separator]; !ok {
+		log.Fatal("Invalid separator given ", opts.Separator)
+	}
+	if _, err := parseFormatTemplate(opts.Format); err != nil {
+		log.Fatal("Invalid format given: ", err)
+	}
+	if opts.MergeOrder != "none" && opts.MergeOrder != MergeArrival {
+		log.Fatal("Invalid merge order given ", opts.MergeOrder)
+	}
+	if opts.MinPoll <= 0 || opts.MaxPoll < opts.MinPoll {
+		log.Fatal("min-poll must be positive and no greater than max-poll")
+	}
+	if opts.ExecBatch < 1 || opts.ExecConcurrency < 1 || opts.ExecBatchWindow <= 0 {
+		log.Fatal("exec-batch, exec-concurrency and exec-batch-window must be positive")
+	}
+	if opts.LambdaBatch < 1 || opts.LambdaBatch > 10000 || opts.LambdaBatchWindow <= 0 {
+		log.Fatal("lambda-batch must be between 1 and 10000 and lambda-batch-window positive")
+	}
+	sinks := 0
+	for _, sink := range []string{opts.Exec, opts.LambdaURL, opts.LambdaExec, opts.OutDir} {
+		if sink != "" {
+			sinks++
+		}
+	}
+	if sinks > 1 {
+		log.Fatal("exec, lambda-url, lambda-exec and out-dir are mutually exclusive")
+	}
+	if opts.OutPartition != "shard" && opts.OutPartition != "hour" && opts.OutPartition != "shard,hour" {
+		log.Fatal("Invalid out-partition given ", opts.OutPartition)
+	}
+	if opts.RotateSize <= 0 || opts.RotateInterval <= 0 {
+		log.Fatal("rotate-size and rotate-interval must be positive")
+	}
+	if opts.ForKey != "" {
+		if opts.ShardId != defaultShardId {
+			log.Fatal("for-key and shardId are mutually exclusive")
+		}
+		if opts.FilterPartitionKey != "" && opts.FilterPartitionKey != opts.ForKey {
+			log.Fatal("for-key and partition-key must name the same key")
+		}
+		opts.FilterPartitionKey = opts.ForKey
+	}
+	if _, err := newRecordFilter(*opts); err != nil {
+		log.Fatal("Invalid filter: ", err)
+	}
+	if since != 0 && from != "" {
+		log.Fatal("since and from are mutually exclusive")
+	}
+	if (since != 0 || from != "") && (opts.ItrType != TrimHorizon || opts.StartingSeqNum != "") {
+		log.Fatal("since and from cannot be combined with iter or sn")
+	}
+	if since != 0 {
+		opts.StartingTimestamp = time.Now().Add(-since)
+	}
+	if from != "" {
+		ts, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			log.Fatal("Invalid from time given ", from)
+		}
+		opts.StartingTimestamp = ts
+	}
 }
 
 func uploadFile(fileName string, opts Options, svc *kinesis.Kinesis, fsvc *firehose.Firehose) {
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// checkpointFile is the on-disk form of a checkpoint: the last sequence
+// number handled in each shard of a stream.
+type checkpointFile struct {
+	StreamName string            `json:"streamName"`
+	Shards     map[string]string `json:"shards"`
+}
+
+type pendingRecord struct {
+	sequenceNumber string
+	done           bool
+}
+
+// checkpointer records how far each shard has been handled. Records are
+// tracked in shard order as they are read and acked by the sink once handled,
+// possibly out of order; a shard's checkpoint only advances past records
+// that have all been acked. A nil checkpointer tracks nothing.
+type checkpointer struct {
+	mu      sync.Mutex
+	path    string
+	file    checkpointFile
+	pending map[string][]*pendingRecord
+	dirty   bool
+	saved   time.Time
+}
+
+// loadCheckpointer reads the checkpoint at path, starting afresh if there is
+// none yet.
+func loadCheckpointer(path, streamName string) (*checkpointer, error) {
+	c := &checkpointer{
+		path:    path,
+		file:    checkpointFile{StreamName: streamName, Shards: make(map[string]string)},
+		pending: make(map[string][]*pendingRecord),
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c.file); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if c.file.StreamName != streamName {
+		return nil, fmt.Errorf("%s is a checkpoint of stream %s, not %s", path, c.file.StreamName, streamName)
+	}
+	if c.file.Shards == nil {
+		c.file.Shards = make(map[string]string)
+	}
+	return c, nil
+}
+
+// get returns the last sequence number handled in shardId, if any.
+func (c *checkpointer) get(shardId string) string {
+	if c == nil {
+		return ""
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.file.Shards[shardId]
+}
+
+// track registers a record read from shardId and returns the func that acks
+// it. It must be called in shard order.
+func (c *checkpointer) track(shardId, sequenceNumber string) func() {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p := &pendingRecord{sequenceNumber: sequenceNumber}
+	c.pending[shardId] = append(c.pending[shardId], p)
+	return func() { c.ack(shardId, p) }
+}
+
+func (c *checkpointer) ack(shardId string, p *pendingRecord) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p.done = true
+	queue := c.pending[shardId]
+	for len(queue) > 0 && queue[0].done {
+		c.file.Shards[shardId] = queue[0].sequenceNumber
+		c.dirty = true
+		queue = queue[1:]
+	}
+	c.pending[shardId] = queue
+	if c.dirty && time.Since(c.saved) >= time.Second {
+		c.saveLocked()
+	}
+}
+
+// save writes the checkpoint if it changed since it was last written.
+func (c *checkpointer) save() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.saveLocked()
+}
+
+func (c *checkpointer) saveLocked() {
+	if !c.dirty {
+		return
+	}
+	data, err := json.MarshalIndent(&c.file, "", "  ")
+	if err != nil {
+		log.Printf("Could not encode checkpoint: %s", err)
+		return
+	}
+	// Write to a temporary file first so a crash never leaves a torn checkpoint.
+	tmp, err := ioutil.TempFile(filepath.Dir(c.path), filepath.Base(c.path))
+	if err != nil {
+		log.Printf("Could not save checkpoint: %s", err)
+		return
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("Could not save checkpoint: %s", err)
+		return
+	}
+	c.dirty = false
+	c.saved = time.Now()
+}
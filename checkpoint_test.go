@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckpointAdvancesOverContiguousAcks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "c2k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint.json")
+
+	c, err := loadCheckpointer(path, "stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ack1 := c.track("shardId-0", "1")
+	ack2 := c.track("shardId-0", "2")
+	ack3 := c.track("shardId-0", "3")
+	ack3()
+	if seq := c.get("shardId-0"); seq != "" {
+		t.Fatalf("Checkpoint advanced past unacked records to %s", seq)
+	}
+	ack1()
+	if seq := c.get("shardId-0"); seq != "1" {
+		t.Fatalf("Expected checkpoint 1 but got %s", seq)
+	}
+	ack2()
+	if seq := c.get("shardId-0"); seq != "3" {
+		t.Fatalf("Expected checkpoint 3 but got %s", seq)
+	}
+	c.save()
+
+	loaded, err := loadCheckpointer(path, "stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq := loaded.get("shardId-0"); seq != "3" {
+		t.Errorf("Expected saved checkpoint 3 but got %s", seq)
+	}
+	if _, err := loadCheckpointer(path, "other-stream"); err == nil {
+		t.Error("Expected a checkpoint of another stream to be rejected")
+	}
+}
+
+func TestNilCheckpointer(t *testing.T) {
+	var c *checkpointer
+	if ack := c.track("shardId-0", "1"); ack != nil {
+		t.Error("Expected no ack func without checkpointing")
+	}
+	if c.get("shardId-0") != "" {
+		t.Error("Expected no checkpoint")
+	}
+	c.save()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+// command is a c2k subcommand. A command either runs itself or, like
+// firehose, groups further subcommands.
+type command struct {
+	name, args, summary string
+	run                 func(cmd *command, args []string)
+	subcommands         []*command
+
+	// path is the full command line name, e.g. "c2k firehose put".
+	path string
+}
+
+var commands = []*command{
+	{name: "put", args: "-s stream [flags] [file ...]", summary: "Put each line of the files, or of stdin, into a stream", run: runPut},
+	{name: "get", args: "-s stream [flags]", summary: "Write the records of a stream to stdout as they arrive", run: runGet},
+	{name: "mirror", args: "-from stream -to stream [flags]", summary: "Copy records from one stream into another as they arrive", run: runMirror},
+	{name: "backup", args: "-s stream -o dir [flags]", summary: "Save every retained record of a stream to a directory", run: runBackup},
+	{name: "restore", args: "-s stream [flags] dir", summary: "Put the records of a backup into a stream", run: runRestore},
+	{name: "streams", summary: "Inspect and manage Kinesis streams", subcommands: []*command{
+		{name: "list", args: "[flags]", summary: "List the streams of the account", run: runStreamsList},
+		{name: "describe", args: "-s stream [flags]", summary: "Show the status, retention, tags and shards of a stream", run: runStreamsDescribe},
+		{name: "create", args: "-s stream [flags]", summary: "Create a stream, optionally waiting until it is ACTIVE", run: runStreamsCreate},
+		{name: "delete", args: "-s stream [flags]", summary: "Delete a stream, optionally waiting until it is gone", run: runStreamsDelete},
+		{name: "reshard", args: "-s stream -target N [flags]", summary: "Split and merge shards until there are N evenly sized ones", run: runStreamsReshard},
+		{name: "retention", args: "-s stream [flags] period", summary: "Change how long a stream keeps records, e.g. 168h", run: runStreamsRetention},
+		{name: "tags", args: "-s stream [flags]", summary: "List the tags of a stream", run: runStreamsTags},
+		{name: "tag", args: "-s stream [flags] key=value ...", summary: "Add or change tags of a stream", run: runStreamsTag},
+		{name: "untag", args: "-s stream [flags] key ...", summary: "Remove tags from a stream", run: runStreamsUntag},
+	}},
+	{name: "firehose", summary: "Work with Kinesis Firehose delivery streams", subcommands: []*command{
+		{name: "put", args: "-s delivery-stream [flags] [file ...]", summary: "Put each line of the files, or of stdin, into a delivery stream", run: runFirehosePut},
+		{name: "list", args: "[flags]", summary: "List the delivery streams of the account", run: runFirehoseList},
+		{name: "describe", args: "-s delivery-stream [flags]", summary: "Show the status, version and destinations of a delivery stream", run: runFirehoseDescribe},
+		{name: "create", args: "-s delivery-stream -spec file [flags]", summary: "Create a delivery stream from a JSON or YAML destination spec", run: runFirehoseCreate},
+		{name: "delete", args: "-s delivery-stream [flags]", summary: "Delete a delivery stream", run: runFirehoseDelete},
+		{name: "update-destination", args: "-s delivery-stream -spec file [flags]", summary: "Change a destination of a delivery stream", run: runFirehoseUpdateDestination},
+	}},
+	{name: "emulate", args: "[flags]", summary: "Serve an in-memory Kinesis and Firehose for local development", run: runEmulate},
+}
+
+func isHelp(arg string) bool {
+	return arg == "-h" || arg == "-help" || arg == "--help" || arg == "help"
+}
+
+// dispatch runs the command named by args[0] with the remaining arguments.
+func dispatch(path string, commands []*command, args []string) {
+	if len(args) == 0 || isHelp(args[0]) {
+		printCommands(path, commands)
+		if len(args) == 0 {
+			os.Exit(2)
+		}
+		return
+	}
+	for _, cmd := range commands {
+		if cmd.name != args[0] {
+			continue
+		}
+		cmd.path = path + " " + cmd.name
+		if cmd.subcommands != nil {
+			dispatch(cmd.path, cmd.subcommands, args[1:])
+		} else {
+			cmd.run(cmd, selectTarget(args[1:]))
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s: unknown command %q\n\n", path, args[0])
+	printCommands(path, commands)
+	os.Exit(2)
+}
+
+func printCommands(path string, commands []*command) {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", path)
+	w := tabwriter.NewWriter(os.Stderr, 0, 8, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s\t%s\n", cmd.name, cmd.summary)
+	}
+	w.Flush()
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the flags of a command.\n", path)
+}
+
+// flagSet returns an empty flag set for cmd whose help describes it.
+func (cmd *command) flagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.path, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [@target] %s\n\n%s.\n\nFlags:\n", cmd.path, cmd.args, cmd.summary)
+		fs.PrintDefaults()
+	}
+	// The target was read before flags are parsed, see selectTarget.
+	fs.String("config", "", configUsage)
+	return fs
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDispatch(t *testing.T) {
+	var ranPath string
+	var ranArgs []string
+	run := func(cmd *command, args []string) {
+		ranPath, ranArgs = cmd.path, args
+	}
+	commands := []*command{
+		{name: "put", run: run},
+		{name: "firehose", subcommands: []*command{
+			{name: "list", run: run},
+			{name: "put", run: run},
+		}},
+	}
+	tests := []struct {
+		args []string
+		path string
+		rest []string
+	}{
+		{[]string{"put", "-s", "stream", "file.log"}, "c2k put", []string{"-s", "stream", "file.log"}},
+		{[]string{"firehose", "put", "-s", "stream"}, "c2k firehose put", []string{"-s", "stream"}},
+		{[]string{"firehose", "list"}, "c2k firehose list", []string{}},
+	}
+	for _, test := range tests {
+		ranPath, ranArgs = "", nil
+		dispatch("c2k", commands, test.args)
+		if ranPath != test.path || !reflect.DeepEqual(ranArgs, test.rest) {
+			t.Errorf("%v ran %q with %v, expected %q with %v", test.args, ranPath, ranArgs, test.path, test.rest)
+		}
+	}
+}
+
+func TestFlagSetUsesCommandPath(t *testing.T) {
+	cmd := &command{name: "get", path: "c2k get"}
+	fs := cmd.flagSet()
+	var opts Options
+	var since time.Duration
+	var from string
+	addStreamFlags(fs, &opts)
+	addGetFlags(fs, &opts, &since, &from)
+	if err := fs.Parse([]string{"-s", "stream", "-since", "2h", "-output", "jsonl"}); err != nil {
+		t.Fatal(err)
+	}
+	if fs.Name() != "c2k get" {
+		t.Errorf("Unexpected flag set name %q", fs.Name())
+	}
+	if opts.StreamName != "stream" || since != 2*time.Hour || opts.Output != OutputJSONL || opts.ItrType != TrimHorizon {
+		t.Errorf("Unexpected options %+v since %s", opts, since)
+	}
+}
+
+func TestExecOutputDefaultsToRaw(t *testing.T) {
+	cases := []struct {
+		args   []string
+		output string
+	}{
+		{nil, OutputLines},
+		{[]string{"-exec", "cat"}, OutputRaw},
+		{[]string{"-exec", "cat", "-output", "lines"}, OutputLines},
+		{[]string{"-output", "jsonl"}, OutputJSONL},
+	}
+	for _, c := range cases {
+		var opts Options
+		var since time.Duration
+		var from string
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		addGetFlags(fs, &opts, &since, &from)
+		if err := fs.Parse(c.args); err != nil {
+			t.Fatal(err)
+		}
+		validateGetOptions(&opts, since, from)
+		if opts.Output != c.output {
+			t.Errorf("%v: expected output %s, got %s", c.args, c.output, opts.Output)
+		}
+	}
+}
+
+func TestEndpointFlags(t *testing.T) {
+	for _, key := range []string{endpointEnv, firehoseEndpointEnv, noSSLEnv} {
+		defer os.Setenv(key, os.Getenv(key))
+	}
+	os.Setenv(endpointEnv, "localhost:4567")
+	os.Setenv(firehoseEndpointEnv, "")
+	os.Setenv(noSSLEnv, "true")
+
+	cases := []struct {
+		args              []string
+		kinesis, firehose string
+	}{
+		{nil, "http://localhost:4567", "http://localhost:4567"},
+		{[]string{"-firehose-endpoint", "localhost:4573"}, "http://localhost:4567", "http://localhost:4573"},
+		{[]string{"-endpoint", "https://kinesis.example.com", "-no-ssl=false"}, "https://kinesis.example.com", "https://kinesis.example.com"},
+	}
+	for _, c := range cases {
+		var opts Options
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		addAWSFlags(fs, &opts)
+		if err := fs.Parse(c.args); err != nil {
+			t.Fatal(err)
+		}
+		if endpoint := createService(opts).Endpoint; endpoint != c.kinesis {
+			t.Errorf("%v: expected Kinesis endpoint %s, got %s", c.args, c.kinesis, endpoint)
+		}
+		if endpoint := createFirehoseService(opts).Endpoint; endpoint != c.firehose {
+			t.Errorf("%v: expected Firehose endpoint %s, got %s", c.args, c.firehose, endpoint)
+		}
+	}
+}
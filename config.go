@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	configEnv   = "C2K_CONFIG"
+	configUsage = "Config file defining @targets (env " + configEnv + ", defaults to ~/.config/c2k/config.yaml)"
+)
+
+// configTarget is a named set of defaults, chosen with c2k <command> @name.
+type configTarget struct {
+	Profile          string `yaml:"profile"`
+	Region           string `yaml:"region"`
+	Endpoint         string `yaml:"endpoint"`
+	FirehoseEndpoint string `yaml:"firehose_endpoint"`
+	NoSSL            bool   `yaml:"no_ssl"`
+	Stream           string `yaml:"stream"`
+	Firehose         bool   `yaml:"firehose"`
+	PartitionKey     string `yaml:"partition_key"`
+	Output           string `yaml:"output"`
+}
+
+type config struct {
+	Targets map[string]*configTarget `yaml:"targets"`
+}
+
+// selectedTarget holds the defaults of the @target the running command was given.
+// Flags and environment variables take precedence over it.
+var selectedTarget = &configTarget{}
+
+func defaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".config")
+	}
+	return filepath.Join(dir, "c2k", "config.yaml")
+}
+
+// configPath returns the config file named by -config in args, or else by
+// C2K_CONFIG, or else the default. The command's flags are not parsed yet
+// when the target is looked up, so -config is found by name.
+func configPath(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		if len(name) == len(arg) || len(arg)-len(name) > 2 {
+			continue
+		}
+		if name == "config" && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(name, "config=") {
+			return strings.TrimPrefix(name, "config=")
+		}
+	}
+	return setting([]string{configEnv}, "", defaultConfigPath())
+}
+
+func readConfig(path string) (*config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c config
+	if err := yaml.UnmarshalStrict(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return &c, nil
+}
+
+// selectTarget sets selectedTarget when args start with @name and returns the
+// remaining arguments.
+func selectTarget(args []string) []string {
+	if len(args) == 0 || !strings.HasPrefix(args[0], "@") {
+		return args
+	}
+	name, args := args[0][1:], args[1:]
+	path := configPath(args)
+	c, err := readConfig(path)
+	if err != nil {
+		log.Fatal("Could not read config: ", err)
+	}
+	t, ok := c.Targets[name]
+	if !ok || t == nil {
+		var names []string
+		for name := range c.Targets {
+			names = append(names, "@"+name)
+		}
+		sort.Strings(names)
+		log.Fatalf("%s defines no target @%s, only %s", path, name, strings.Join(names, ", "))
+	}
+	selectedTarget = t
+	return args
+}
+
+// setting returns the default for a flag: the first of the environment
+// variables envs that is set, then the target's value, then def.
+func setting(envs []string, targetValue, def string) string {
+	for _, env := range envs {
+		if value := os.Getenv(env); value != "" {
+			return value
+		}
+	}
+	if targetValue != "" {
+		return targetValue
+	}
+	return def
+}
+
+// profileSetting leaves an AWS_PROFILE to the shared credentials provider,
+// which only reads it for the default profile.
+func profileSetting() string {
+	if os.Getenv("AWS_PROFILE") != "" {
+		return defaultProfile
+	}
+	return setting(nil, selectedTarget.Profile, defaultProfile)
+}
+
+func noSSLSetting() bool {
+	if os.Getenv(noSSLEnv) != "" {
+		return envBool(noSSLEnv)
+	}
+	return selectedTarget.NoSSL
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testConfig = `targets:
+  clicks:
+    profile: prod
+    region: eu-west-1
+    endpoint: http://localhost:4567
+    stream: clickstream
+    firehose: true
+    partition_key: hash
+    output: jsonl
+`
+
+func TestConfigPath(t *testing.T) {
+	defer os.Setenv(configEnv, os.Getenv(configEnv))
+	os.Setenv(configEnv, "")
+	cases := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"-config", "a.yaml", "file"}, "a.yaml"},
+		{[]string{"-s", "x", "--config=b.yaml"}, "b.yaml"},
+		{[]string{"---config", "c.yaml"}, defaultConfigPath()},
+		{[]string{"--", "-config", "d.yaml"}, defaultConfigPath()},
+		{[]string{"file"}, defaultConfigPath()},
+	}
+	for _, c := range cases {
+		if path := configPath(c.args); path != c.expected {
+			t.Errorf("%v: expected %s, got %s", c.args, c.expected, path)
+		}
+	}
+	os.Setenv(configEnv, "e.yaml")
+	if path := configPath(nil); path != "e.yaml" {
+		t.Errorf("Expected %s from the environment, got %s", "e.yaml", path)
+	}
+}
+
+func TestReadConfigRejectsUnknownKeys(t *testing.T) {
+	f, err := ioutil.TempFile("", "c2k-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("targets:\n  clicks:\n    steam: typo\n")
+	f.Close()
+	if _, err := readConfig(f.Name()); err == nil || !strings.Contains(err.Error(), "steam") {
+		t.Errorf("Expected an error naming the unknown key, got %v", err)
+	}
+}
+
+func TestTargetPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "c2k-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(testConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{"AWS_REGION": "us-west-2", "AWS_DEFAULT_REGION": "", "AWS_PROFILE": "", endpointEnv: "", noSSLEnv: ""}
+	for key, value := range env {
+		defer os.Setenv(key, os.Getenv(key))
+		os.Setenv(key, value)
+	}
+	defer func() { selectedTarget = &configTarget{} }()
+
+	args := selectTarget([]string{"@clicks", "-config", path, "-pk", "user", "access.log"})
+	var opts Options
+	var firehose bool
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("config", "", configUsage)
+	fs.BoolVar(&firehose, "f", selectedTarget.Firehose, "")
+	addStreamFlags(fs, &opts)
+	addPutFlags(fs, &opts)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fs.Args(), []string{"access.log"}) {
+		t.Errorf("Unexpected arguments %v", fs.Args())
+	}
+	// The flag beats the config, the environment beats the config, and the
+	// config beats the defaults.
+	if opts.PartitionKey != "user" {
+		t.Errorf("Expected the partition key flag, got %s", opts.PartitionKey)
+	}
+	if opts.Region != "us-west-2" {
+		t.Errorf("Expected the region from the environment, got %s", opts.Region)
+	}
+	if opts.Profile != "prod" || opts.StreamName != "clickstream" || opts.Endpoint != "http://localhost:4567" || !firehose {
+		t.Errorf("Expected the target's settings, got %+v", opts)
+	}
+
+	var getOpts Options
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	addGetFlags(fs, &getOpts, new(time.Duration), new(string))
+	fs.Parse(nil)
+	if getOpts.Output != OutputJSONL {
+		t.Errorf("Expected the target's output, got %s", getOpts.Output)
+	}
+}
+
+func TestProfileSettingLeavesAWSProfile(t *testing.T) {
+	defer os.Setenv("AWS_PROFILE", os.Getenv("AWS_PROFILE"))
+	defer func() { selectedTarget = &configTarget{} }()
+	selectedTarget = &configTarget{Profile: "prod"}
+	os.Setenv("AWS_PROFILE", "")
+	if profile := profileSetting(); profile != "prod" {
+		t.Errorf("Expected the target's profile, got %s", profile)
+	}
+	os.Setenv("AWS_PROFILE", "dev")
+	if profile := profileSetting(); profile != defaultProfile {
+		t.Errorf("Expected AWS_PROFILE to be left to the credentials chain, got %s", profile)
+	}
+}
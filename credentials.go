@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// assumeRoleDuration is how long assumed role credentials last. With
+	// -mfa-serial a new code is asked for when they run out.
+	assumeRoleDuration = time.Hour
+	expiryWindow       = time.Minute
+)
+
+var (
+	credentialsMu    sync.Mutex
+	credentialsCache = make(map[string]*credentials.Credentials)
+)
+
+// emulatorCredentials are used against a custom endpoint when no others are
+// found, as local emulators accept any signature.
+var emulatorCredentials = credentials.Value{AccessKeyID: "c2k", SecretAccessKey: "c2k"}
+
+// credentialsFor returns the credentials for opts: those of the chosen
+// profile, or else the first of the environment, the shared credentials file
+// and the EC2 instance role that has any, used to assume opts.RoleARN when it
+// is set. Clients with the same options share credentials, so an MFA code is
+// only asked for once.
+func credentialsFor(opts Options) *credentials.Credentials {
+	emulator := opts.RoleARN == "" && (opts.Endpoint != "" || opts.FirehoseEndpoint != "")
+	key := strings.Join([]string{opts.Profile, opts.RoleARN, opts.ExternalID, opts.MFASerial, fmt.Sprint(emulator)}, "\x00")
+	credentialsMu.Lock()
+	defer credentialsMu.Unlock()
+	if creds, ok := credentialsCache[key]; ok {
+		return creds
+	}
+	creds, err := sourceCredentials(opts.Profile, emulator)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if opts.RoleARN != "" {
+		creds = assumeRole(creds, opts)
+	}
+	credentialsCache[key] = creds
+	return creds
+}
+
+// sourceCredentials chains the credential providers. A profile chosen with
+// -p or by a target is the only source, so a missing profile is an error
+// rather than a silent fall back to other credentials. Otherwise the
+// environment wins and the shared file falls back to AWS_PROFILE or the
+// default profile. With emulator set, made up credentials are used when no
+// others are found.
+func sourceCredentials(profile string, emulator bool) (*credentials.Credentials, error) {
+	if profile != defaultProfile {
+		creds := credentials.NewCredentials(&credentials.SharedCredentialsProvider{Profile: profile})
+		if _, err := creds.Get(); err != nil {
+			return nil, fmt.Errorf("Could not load credentials for profile %s: %s", profile, err)
+		}
+		return creds, nil
+	}
+	providers := []credentials.Provider{
+		&credentials.EnvProvider{},
+		&credentials.SharedCredentialsProvider{},
+		&ec2rolecreds.EC2RoleProvider{ExpiryWindow: 5 * time.Minute},
+	}
+	if emulator {
+		providers = append(providers, &credentials.StaticProvider{Value: emulatorCredentials})
+	}
+	return credentials.NewChainCredentials(providers), nil
+}
+
+// assumeRole returns credentials for opts.RoleARN, assumed with creds and
+// renewed as they run out.
+func assumeRole(creds *credentials.Credentials, opts Options) *credentials.Credentials {
+	client := sts.New(&aws.Config{Credentials: creds, Region: aws.String(opts.Region)})
+	return credentials.NewCredentials(newAssumeRoleProvider(client, opts, promptTokenCode))
+}
+
+// newAssumeRoleProvider configures the vendored stscreds provider for opts,
+// asking tokenCode for an MFA code when opts.MFASerial is set.
+func newAssumeRoleProvider(client stscreds.AssumeRoler, opts Options, tokenCode func(serial string) (string, error)) *stscreds.AssumeRoleProvider {
+	if opts.MFASerial != "" {
+		client = &mfaAssumeRoler{client: client, serial: opts.MFASerial, tokenCode: tokenCode}
+	}
+	provider := &stscreds.AssumeRoleProvider{
+		Client:          client,
+		RoleARN:         opts.RoleARN,
+		RoleSessionName: fmt.Sprintf("c2k-%d", time.Now().UnixNano()),
+		Duration:        assumeRoleDuration,
+		ExpiryWindow:    expiryWindow,
+	}
+	if opts.ExternalID != "" {
+		provider.ExternalID = aws.String(opts.ExternalID)
+	}
+	return provider
+}
+
+// mfaAssumeRoler adds an MFA code to each AssumeRole call, as the stscreds
+// provider has no way to pass one.
+type mfaAssumeRoler struct {
+	client    stscreds.AssumeRoler
+	serial    string
+	tokenCode func(serial string) (string, error)
+}
+
+func (r *mfaAssumeRoler) AssumeRole(input *sts.AssumeRoleInput) (*sts.AssumeRoleOutput, error) {
+	code, err := r.tokenCode(r.serial)
+	if err != nil {
+		return nil, err
+	}
+	input.SerialNumber = aws.String(r.serial)
+	input.TokenCode = aws.String(code)
+	return r.client.AssumeRole(input)
+}
+
+// promptTokenCode asks for an MFA code on the terminal, which keeps working
+// while stdin carries records to put.
+func promptTokenCode(serial string) (string, error) {
+	var r io.Reader = os.Stdin
+	var w io.Writer = os.Stderr
+	if tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0); err == nil {
+		defer tty.Close()
+		r, w = tty, tty
+	}
+	fmt.Fprintf(w, "MFA code for %s: ", serial)
+	code, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && code == "" {
+		return "", err
+	}
+	return strings.TrimSpace(code), nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const assumeRoleResponse = `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>ASIAEXAMPLE</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>token</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+  </AssumeRoleResult>
+</AssumeRoleResponse>`
+
+func newTestAssumeRoleProvider(url string, tokenCode func(string) (string, error)) *stscreds.AssumeRoleProvider {
+	client := sts.New(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(url),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:  aws.Int(0),
+	})
+	opts := Options{
+		RoleARN:    "arn:aws:iam::123456789012:role/reader",
+		ExternalID: "ext",
+		MFASerial:  "arn:aws:iam::123456789012:mfa/someone",
+	}
+	return newAssumeRoleProvider(client, opts, tokenCode)
+}
+
+func TestAssumeRoleProvider(t *testing.T) {
+	expiration := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			t.Error("AssumeRole request was not signed")
+		}
+		r.ParseForm()
+		expected := map[string]string{
+			"Action":          "AssumeRole",
+			"Version":         "2011-06-15",
+			"RoleArn":         "arn:aws:iam::123456789012:role/reader",
+			"ExternalId":      "ext",
+			"SerialNumber":    "arn:aws:iam::123456789012:mfa/someone",
+			"TokenCode":       "123456",
+			"DurationSeconds": "3600",
+		}
+		for key, value := range expected {
+			if r.PostForm.Get(key) != value {
+				t.Errorf("Expected %s %q, got %q", key, value, r.PostForm.Get(key))
+			}
+		}
+		fmt.Fprintf(w, assumeRoleResponse, expiration.Format("2006-01-02T15:04:05.000Z"))
+	}))
+	defer server.Close()
+
+	prompts := 0
+	p := newTestAssumeRoleProvider(server.URL, func(serial string) (string, error) {
+		prompts++
+		return "123456", nil
+	})
+	creds := credentials.NewCredentials(p)
+	for i := 0; i < 2; i++ {
+		value, err := creds.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value.AccessKeyID != "ASIAEXAMPLE" || value.SecretAccessKey != "secret" || value.SessionToken != "token" {
+			t.Errorf("Unexpected credentials %+v", value)
+		}
+	}
+	if prompts != 1 {
+		t.Errorf("Expected one MFA prompt, got %d", prompts)
+	}
+	if p.IsExpired() {
+		t.Error("Assumed credentials expired immediately")
+	}
+}
+
+func TestAssumeRoleProviderError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `<ErrorResponse><Error><Type>Sender</Type><Code>AccessDenied</Code><Message>not allowed</Message></Error><RequestId>abc</RequestId></ErrorResponse>`)
+	}))
+	defer server.Close()
+
+	p := newTestAssumeRoleProvider(server.URL, func(string) (string, error) { return "000000", nil })
+	_, err := p.Retrieve()
+	aerr, ok := err.(awserr.RequestFailure)
+	if !ok {
+		t.Fatalf("Expected a request failure, got %v", err)
+	}
+	if aerr.Code() != "AccessDenied" || aerr.Message() != "not allowed" || aerr.StatusCode() != http.StatusForbidden {
+		t.Errorf("Unexpected error %v", aerr)
+	}
+}
+
+func TestSourceCredentialsOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "c2k-credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "credentials")
+	ini := "[default]\naws_access_key_id = default-id\naws_secret_access_key = s\n[other]\naws_access_key_id = other-id\naws_secret_access_key = s\n"
+	if err := ioutil.WriteFile(file, []byte(ini), 0600); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{
+		"AWS_SHARED_CREDENTIALS_FILE": file,
+		"AWS_ACCESS_KEY_ID":           "env-id",
+		"AWS_SECRET_ACCESS_KEY":       "s",
+		"AWS_PROFILE":                 "",
+	}
+	for key, value := range env {
+		defer os.Setenv(key, os.Getenv(key))
+		os.Setenv(key, value)
+	}
+
+	cases := []struct {
+		profile, envID, expected string
+		emulator                 bool
+	}{
+		{defaultProfile, "env-id", "env-id", false},
+		{"other", "env-id", "other-id", false},
+		{defaultProfile, "", "default-id", false},
+		{"other", "env-id", "other-id", true},
+		{defaultProfile, "", "default-id", true},
+	}
+	for _, c := range cases {
+		os.Setenv("AWS_ACCESS_KEY_ID", c.envID)
+		creds, err := sourceCredentials(c.profile, c.emulator)
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, err := creds.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value.AccessKeyID != c.expected {
+			t.Errorf("Profile %s with env key %q: expected %s, got %s", c.profile, c.envID, c.expected, value.AccessKeyID)
+		}
+	}
+}
+
+func TestSourceCredentialsMissingProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "c2k-credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "credentials")
+	if err := ioutil.WriteFile(file, []byte("[default]\naws_access_key_id = default-id\naws_secret_access_key = s\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{
+		"AWS_SHARED_CREDENTIALS_FILE": file,
+		"AWS_ACCESS_KEY_ID":           "env-id",
+		"AWS_SECRET_ACCESS_KEY":       "s",
+	}
+	for key, value := range env {
+		defer os.Setenv(key, os.Getenv(key))
+		os.Setenv(key, value)
+	}
+
+	// Neither the environment nor the emulator credentials stand in for it.
+	for _, emulator := range []bool{false, true} {
+		_, err := sourceCredentials("missing", emulator)
+		if err == nil || !strings.Contains(err.Error(), "profile missing") {
+			t.Errorf("Expected an error naming the missing profile, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,846 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	kinesisTargetPrefix  = "Kinesis_20131202"
+	firehoseTargetPrefix = "Firehose_20150804"
+	emulatorStateFile    = "streams.json"
+	maxRetentionHours    = 8760
+	maxRecordSize        = 1024 * 1024
+)
+
+// sequenceNumberBase makes emulated sequence numbers as long as real ones, so
+// they sort the same as strings and as numbers.
+var sequenceNumberBase, _ = new(big.Int).SetString("49000000000000000000000000000000000000000000000000000000", 10)
+
+// emulator serves the Kinesis and Firehose JSON 1.1 APIs for the operations
+// c2k uses, keeping streams in memory and optionally saving them to a file.
+// Resharding and creating or deleting streams take effect immediately.
+type emulator struct {
+	mu      sync.Mutex
+	state   emulatorState
+	dirty   bool
+	path    string
+	outDir  string
+	now     func() time.Time
+	targets map[string]func(e *emulator, body []byte) (interface{}, error)
+}
+
+type emulatorState struct {
+	Streams map[string]*emulatedStream `json:"streams"`
+	// NextSequence numbers records across all streams, so sequence numbers
+	// only ever grow.
+	NextSequence int64 `json:"nextSequence"`
+}
+
+type emulatedStream struct {
+	Name           string            `json:"name"`
+	Created        time.Time         `json:"created"`
+	RetentionHours int64             `json:"retentionHours"`
+	Tags           map[string]string `json:"tags"`
+	Shards         []*emulatedShard  `json:"shards"`
+}
+
+type emulatedShard struct {
+	ShardId               string            `json:"shardId"`
+	ParentShardId         string            `json:"parentShardId,omitempty"`
+	AdjacentParentShardId string            `json:"adjacentParentShardId,omitempty"`
+	StartingHashKey       string            `json:"startingHashKey"`
+	EndingHashKey         string            `json:"endingHashKey"`
+	StartingSequence      int64             `json:"startingSequence"`
+	EndingSequence        int64             `json:"endingSequence,omitempty"`
+	Records               []*emulatedRecord `json:"records"`
+}
+
+type emulatedRecord struct {
+	Sequence     int64     `json:"sequence"`
+	PartitionKey string    `json:"partitionKey"`
+	Data         []byte    `json:"data"`
+	Arrival      time.Time `json:"arrival"`
+}
+
+// emulatorError is returned to clients as a JSON 1.1 error response.
+type emulatorError struct {
+	code, message string
+}
+
+func (err *emulatorError) Error() string {
+	return err.code + ": " + err.message
+}
+
+func invalidArgument(format string, args ...interface{}) error {
+	return &emulatorError{"InvalidArgumentException", fmt.Sprintf(format, args...)}
+}
+
+func streamNotFound(name string) error {
+	return &emulatorError{"ResourceNotFoundException", fmt.Sprintf("Stream %s not found", name)}
+}
+
+// newEmulator returns an emulator saving its streams to path, unless path is
+// empty, and writing Firehose records below outDir.
+func newEmulator(path, outDir string) (*emulator, error) {
+	e := &emulator{
+		state:  emulatorState{Streams: make(map[string]*emulatedStream)},
+		path:   path,
+		outDir: outDir,
+		now:    time.Now,
+		targets: map[string]func(e *emulator, body []byte) (interface{}, error){
+			kinesisTargetPrefix + ".CreateStream":                  (*emulator).createStream,
+			kinesisTargetPrefix + ".DeleteStream":                  (*emulator).deleteStream,
+			kinesisTargetPrefix + ".DescribeStream":                (*emulator).describeStream,
+			kinesisTargetPrefix + ".ListStreams":                   (*emulator).listStreams,
+			kinesisTargetPrefix + ".PutRecord":                     (*emulator).putRecord,
+			kinesisTargetPrefix + ".PutRecords":                    (*emulator).putRecords,
+			kinesisTargetPrefix + ".GetShardIterator":              (*emulator).getShardIterator,
+			kinesisTargetPrefix + ".GetRecords":                    (*emulator).getRecords,
+			kinesisTargetPrefix + ".SplitShard":                    (*emulator).splitShard,
+			kinesisTargetPrefix + ".MergeShards":                   (*emulator).mergeShards,
+			kinesisTargetPrefix + ".IncreaseStreamRetentionPeriod": (*emulator).increaseRetention,
+			kinesisTargetPrefix + ".DecreaseStreamRetentionPeriod": (*emulator).decreaseRetention,
+			kinesisTargetPrefix + ".AddTagsToStream":               (*emulator).addTags,
+			kinesisTargetPrefix + ".RemoveTagsFromStream":          (*emulator).removeTags,
+			kinesisTargetPrefix + ".ListTagsForStream":             (*emulator).listTags,
+			firehoseTargetPrefix + ".PutRecord":                    (*emulator).putFirehoseRecord,
+			firehoseTargetPrefix + ".PutRecordBatch":               (*emulator).putFirehoseRecordBatch,
+		},
+	}
+	if path == "" {
+		return e, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return e, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &e.state); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return e, nil
+}
+
+func (e *emulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target := r.Header.Get("X-Amz-Target")
+	handle, ok := e.targets[target]
+	var out interface{}
+	var err error
+	if !ok {
+		err = &emulatorError{"UnknownOperationException", fmt.Sprintf("c2k emulate does not support %s", target)}
+	} else if body, readErr := ioutil.ReadAll(r.Body); readErr != nil {
+		err = &emulatorError{"SerializationException", readErr.Error()}
+	} else {
+		e.mu.Lock()
+		out, err = handle(e, body)
+		e.mu.Unlock()
+	}
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	if err != nil {
+		eerr, ok := err.(*emulatorError)
+		if !ok {
+			eerr = &emulatorError{"InternalFailure", err.Error()}
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"__type": eerr.code, "message": eerr.message})
+		return
+	}
+	json.NewEncoder(w).Encode(out)
+}
+
+func decodeInput(body []byte, in interface{}) error {
+	if err := json.Unmarshal(body, in); err != nil {
+		return &emulatorError{"SerializationException", err.Error()}
+	}
+	return nil
+}
+
+// save writes the streams to e.path if they changed since the last save.
+func (e *emulator) save() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.path == "" || !e.dirty {
+		return nil
+	}
+	data, err := json.Marshal(&e.state)
+	if err != nil {
+		return err
+	}
+	tmp := e.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, e.path); err != nil {
+		return err
+	}
+	e.dirty = false
+	return nil
+}
+
+func (e *emulator) stream(name string) (*emulatedStream, error) {
+	stream, ok := e.state.Streams[name]
+	if !ok {
+		return nil, streamNotFound(name)
+	}
+	return stream, nil
+}
+
+func (e *emulator) nextSequence() int64 {
+	e.state.NextSequence++
+	return e.state.NextSequence
+}
+
+func formatSequence(n int64) string {
+	return new(big.Int).Add(sequenceNumberBase, big.NewInt(n)).String()
+}
+
+func parseSequence(s string) (int64, error) {
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok || n.Cmp(sequenceNumberBase) < 0 {
+		return 0, invalidArgument("Invalid sequence number %s", s)
+	}
+	return n.Sub(n, sequenceNumberBase).Int64(), nil
+}
+
+func (stream *emulatedStream) shard(id string) (*emulatedShard, error) {
+	for _, shard := range stream.Shards {
+		if shard.ShardId == id {
+			return shard, nil
+		}
+	}
+	return nil, &emulatorError{"ResourceNotFoundException", fmt.Sprintf("Shard %s in stream %s not found", id, stream.Name)}
+}
+
+func (stream *emulatedStream) addShard(parent, adjacentParent string, r hashRange, startingSequence int64) {
+	stream.Shards = append(stream.Shards, &emulatedShard{
+		ShardId:               fmt.Sprintf("shardId-%012d", len(stream.Shards)),
+		ParentShardId:         parent,
+		AdjacentParentShardId: adjacentParent,
+		StartingHashKey:       r.start.String(),
+		EndingHashKey:         r.end.String(),
+		StartingSequence:      startingSequence,
+	})
+}
+
+func (shard *emulatedShard) open() bool {
+	return shard.EndingSequence == 0
+}
+
+func (shard *emulatedShard) hashRange() hashRange {
+	start, _ := new(big.Int).SetString(shard.StartingHashKey, 10)
+	end, _ := new(big.Int).SetString(shard.EndingHashKey, 10)
+	return hashRange{start, end}
+}
+
+func (e *emulator) createStream(body []byte) (interface{}, error) {
+	var in struct {
+		StreamName string
+		ShardCount int
+	}
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	if in.StreamName == "" || in.ShardCount < 1 {
+		return nil, invalidArgument("CreateStream requires a StreamName and a positive ShardCount")
+	}
+	if _, ok := e.state.Streams[in.StreamName]; ok {
+		return nil, &emulatorError{"ResourceInUseException", fmt.Sprintf("Stream %s already exists", in.StreamName)}
+	}
+	stream := &emulatedStream{Name: in.StreamName, Created: e.now(), RetentionHours: 24, Tags: make(map[string]string)}
+	for _, r := range uniformRanges(in.ShardCount) {
+		stream.addShard("", "", r, e.nextSequence())
+	}
+	e.state.Streams[in.StreamName] = stream
+	e.dirty = true
+	return struct{}{}, nil
+}
+
+func (e *emulator) deleteStream(body []byte) (interface{}, error) {
+	var in struct{ StreamName string }
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	if _, err := e.stream(in.StreamName); err != nil {
+		return nil, err
+	}
+	delete(e.state.Streams, in.StreamName)
+	e.dirty = true
+	return struct{}{}, nil
+}
+
+type shardOutput struct {
+	ShardId               string
+	ParentShardId         string `json:",omitempty"`
+	AdjacentParentShardId string `json:",omitempty"`
+	HashKeyRange          struct{ StartingHashKey, EndingHashKey string }
+	SequenceNumberRange   struct {
+		StartingSequenceNumber string
+		EndingSequenceNumber   string `json:",omitempty"`
+	}
+}
+
+func (e *emulator) describeStream(body []byte) (interface{}, error) {
+	var in struct {
+		StreamName            string
+		Limit                 int
+		ExclusiveStartShardId string
+	}
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	stream, err := e.stream(in.StreamName)
+	if err != nil {
+		return nil, err
+	}
+	if in.Limit < 1 || in.Limit > 100 {
+		in.Limit = 100
+	}
+	shards := []shardOutput{}
+	hasMore := false
+	started := in.ExclusiveStartShardId == ""
+	for _, shard := range stream.Shards {
+		if !started {
+			started = shard.ShardId == in.ExclusiveStartShardId
+			continue
+		}
+		if len(shards) == in.Limit {
+			hasMore = true
+			break
+		}
+		out := shardOutput{ShardId: shard.ShardId, ParentShardId: shard.ParentShardId, AdjacentParentShardId: shard.AdjacentParentShardId}
+		out.HashKeyRange.StartingHashKey = shard.StartingHashKey
+		out.HashKeyRange.EndingHashKey = shard.EndingHashKey
+		out.SequenceNumberRange.StartingSequenceNumber = formatSequence(shard.StartingSequence)
+		if !shard.open() {
+			out.SequenceNumberRange.EndingSequenceNumber = formatSequence(shard.EndingSequence)
+		}
+		shards = append(shards, out)
+	}
+	return map[string]interface{}{"StreamDescription": map[string]interface{}{
+		"StreamName":           stream.Name,
+		"StreamARN":            "arn:aws:kinesis:local:000000000000:stream/" + stream.Name,
+		"StreamStatus":         "ACTIVE",
+		"RetentionPeriodHours": stream.RetentionHours,
+		"HasMoreShards":        hasMore,
+		"Shards":               shards,
+	}}, nil
+}
+
+func (e *emulator) listStreams(body []byte) (interface{}, error) {
+	var in struct {
+		Limit                    int
+		ExclusiveStartStreamName string
+	}
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	if in.Limit < 1 {
+		in.Limit = 10
+	}
+	var names []string
+	for name := range e.state.Streams {
+		if name > in.ExclusiveStartStreamName {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	hasMore := len(names) > in.Limit
+	if hasMore {
+		names = names[:in.Limit]
+	}
+	if names == nil {
+		names = []string{}
+	}
+	return map[string]interface{}{"StreamNames": names, "HasMoreStreams": hasMore}, nil
+}
+
+type putRecordInput struct {
+	Data            []byte
+	PartitionKey    string
+	ExplicitHashKey string
+}
+
+type putRecordOutput struct {
+	ShardId        string
+	SequenceNumber string
+}
+
+// put appends a record to the open shard owning its hash key.
+func (e *emulator) put(stream *emulatedStream, in putRecordInput) (putRecordOutput, error) {
+	if len(in.PartitionKey) < 1 || len(in.PartitionKey) > 256 {
+		return putRecordOutput{}, invalidArgument("PartitionKey must be 1 to 256 characters long")
+	}
+	if len(in.Data) > maxRecordSize {
+		return putRecordOutput{}, invalidArgument("Data must be at most 1 MiB")
+	}
+	hash := partitionKeyHash(in.PartitionKey)
+	if in.ExplicitHashKey != "" {
+		var ok bool
+		if hash, ok = new(big.Int).SetString(in.ExplicitHashKey, 10); !ok || hash.Sign() < 0 || hash.Cmp(hashKeySpace) >= 0 {
+			return putRecordOutput{}, invalidArgument("Invalid ExplicitHashKey %s", in.ExplicitHashKey)
+		}
+	}
+	for _, shard := range stream.Shards {
+		r := shard.hashRange()
+		if !shard.open() || r.start.Cmp(hash) > 0 || hash.Cmp(r.end) > 0 {
+			continue
+		}
+		record := &emulatedRecord{Sequence: e.nextSequence(), PartitionKey: in.PartitionKey, Data: in.Data, Arrival: e.now()}
+		shard.Records = append(shard.Records, record)
+		e.dirty = true
+		return putRecordOutput{shard.ShardId, formatSequence(record.Sequence)}, nil
+	}
+	return putRecordOutput{}, fmt.Errorf("no open shard of %s owns hash key %s", stream.Name, hash)
+}
+
+func (e *emulator) putRecord(body []byte) (interface{}, error) {
+	var in struct {
+		StreamName string
+		putRecordInput
+	}
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	stream, err := e.stream(in.StreamName)
+	if err != nil {
+		return nil, err
+	}
+	return e.put(stream, in.putRecordInput)
+}
+
+func (e *emulator) putRecords(body []byte) (interface{}, error) {
+	var in struct {
+		StreamName string
+		Records    []putRecordInput
+	}
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	stream, err := e.stream(in.StreamName)
+	if err != nil {
+		return nil, err
+	}
+	if len(in.Records) < 1 || len(in.Records) > MaxPutIdx+1 {
+		return nil, invalidArgument("PutRecords takes 1 to 500 records")
+	}
+	results := make([]putRecordOutput, len(in.Records))
+	for i, record := range in.Records {
+		if results[i], err = e.put(stream, record); err != nil {
+			return nil, err
+		}
+	}
+	return map[string]interface{}{"FailedRecordCount": 0, "Records": results}, nil
+}
+
+// emulatedIterator is what an emulated shard iterator stands for: the index
+// of the next record to read in a shard. Created tells a recreated stream of
+// the same name apart.
+type emulatedIterator struct {
+	Stream  string    `json:"s"`
+	Created time.Time `json:"c"`
+	ShardId string    `json:"i"`
+	Next    int       `json:"n"`
+}
+
+func (it emulatedIterator) String() string {
+	data, _ := json.Marshal(it)
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+func (e *emulator) getShardIterator(body []byte) (interface{}, error) {
+	var in struct {
+		StreamName, ShardId, ShardIteratorType, StartingSequenceNumber string
+		Timestamp                                                      float64
+	}
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	stream, err := e.stream(in.StreamName)
+	if err != nil {
+		return nil, err
+	}
+	shard, err := stream.shard(in.ShardId)
+	if err != nil {
+		return nil, err
+	}
+	it := emulatedIterator{Stream: stream.Name, Created: stream.Created, ShardId: shard.ShardId}
+	switch in.ShardIteratorType {
+	case TrimHorizon:
+	case Latest:
+		it.Next = len(shard.Records)
+	case AtSequenceNum, AfterSequenceNum:
+		seq, err := parseSequence(in.StartingSequenceNumber)
+		if err != nil {
+			return nil, err
+		}
+		if in.ShardIteratorType == AfterSequenceNum {
+			seq++
+		}
+		it.Next = sort.Search(len(shard.Records), func(i int) bool { return shard.Records[i].Sequence >= seq })
+	case AtTimestamp:
+		ts := time.Unix(0, int64(in.Timestamp*float64(time.Second)))
+		it.Next = sort.Search(len(shard.Records), func(i int) bool { return !shard.Records[i].Arrival.Before(ts) })
+	default:
+		return nil, invalidArgument("Invalid ShardIteratorType %s", in.ShardIteratorType)
+	}
+	return map[string]string{"ShardIterator": it.String()}, nil
+}
+
+type recordOutput struct {
+	SequenceNumber              string
+	PartitionKey                string
+	Data                        []byte
+	ApproximateArrivalTimestamp int64
+}
+
+func (e *emulator) getRecords(body []byte) (interface{}, error) {
+	var in struct {
+		ShardIterator string
+		Limit         int
+	}
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	var it emulatedIterator
+	data, err := base64.StdEncoding.DecodeString(in.ShardIterator)
+	if err == nil {
+		err = json.Unmarshal(data, &it)
+	}
+	if err != nil {
+		return nil, invalidArgument("Invalid ShardIterator")
+	}
+	stream, err := e.stream(it.Stream)
+	if err != nil || !stream.Created.Equal(it.Created) {
+		return nil, &emulatorError{"ExpiredIteratorException", "Stream was deleted"}
+	}
+	shard, err := stream.shard(it.ShardId)
+	if err != nil {
+		return nil, err
+	}
+	if in.Limit < 1 || in.Limit > 10000 {
+		in.Limit = 10000
+	}
+	end := it.Next + in.Limit
+	if end > len(shard.Records) {
+		end = len(shard.Records)
+	}
+	records := make([]recordOutput, 0, end-it.Next)
+	for _, record := range shard.Records[it.Next:end] {
+		records = append(records, recordOutput{formatSequence(record.Sequence), record.PartitionKey, record.Data, record.Arrival.Unix()})
+	}
+	out := map[string]interface{}{"Records": records, "MillisBehindLatest": 0}
+	if end < len(shard.Records) {
+		out["MillisBehindLatest"] = int64(e.now().Sub(shard.Records[end].Arrival) / time.Millisecond)
+	}
+	// A closed shard that has been read to the end has no next iterator.
+	if shard.open() || end < len(shard.Records) {
+		it.Next = end
+		out["NextShardIterator"] = it.String()
+	}
+	return out, nil
+}
+
+func (e *emulator) splitShard(body []byte) (interface{}, error) {
+	var in struct{ StreamName, ShardToSplit, NewStartingHashKey string }
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	stream, err := e.stream(in.StreamName)
+	if err != nil {
+		return nil, err
+	}
+	shard, err := stream.shard(in.ShardToSplit)
+	if err != nil {
+		return nil, err
+	}
+	if !shard.open() {
+		return nil, invalidArgument("Shard %s is closed", shard.ShardId)
+	}
+	r := shard.hashRange()
+	at, ok := new(big.Int).SetString(in.NewStartingHashKey, 10)
+	if !ok || at.Cmp(r.start) <= 0 || at.Cmp(r.end) > 0 {
+		return nil, invalidArgument("NewStartingHashKey %s is not inside shard %s", in.NewStartingHashKey, shard.ShardId)
+	}
+	shard.EndingSequence = e.nextSequence()
+	stream.addShard(shard.ShardId, "", hashRange{r.start, new(big.Int).Sub(at, big.NewInt(1))}, e.nextSequence())
+	stream.addShard(shard.ShardId, "", hashRange{at, r.end}, e.nextSequence())
+	e.dirty = true
+	return struct{}{}, nil
+}
+
+func (e *emulator) mergeShards(body []byte) (interface{}, error) {
+	var in struct{ StreamName, ShardToMerge, AdjacentShardToMerge string }
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	stream, err := e.stream(in.StreamName)
+	if err != nil {
+		return nil, err
+	}
+	shard, err := stream.shard(in.ShardToMerge)
+	if err != nil {
+		return nil, err
+	}
+	adjacent, err := stream.shard(in.AdjacentShardToMerge)
+	if err != nil {
+		return nil, err
+	}
+	if !shard.open() || !adjacent.open() {
+		return nil, invalidArgument("Shards %s and %s must both be open", shard.ShardId, adjacent.ShardId)
+	}
+	r, ar := shard.hashRange(), adjacent.hashRange()
+	if ar.start.Cmp(r.start) < 0 {
+		r, ar = ar, r
+	}
+	if new(big.Int).Add(r.end, big.NewInt(1)).Cmp(ar.start) != 0 {
+		return nil, invalidArgument("Shards %s and %s are not adjacent", shard.ShardId, adjacent.ShardId)
+	}
+	shard.EndingSequence = e.nextSequence()
+	adjacent.EndingSequence = shard.EndingSequence
+	stream.addShard(shard.ShardId, adjacent.ShardId, hashRange{r.start, ar.end}, e.nextSequence())
+	e.dirty = true
+	return struct{}{}, nil
+}
+
+type retentionInput struct {
+	StreamName           string
+	RetentionPeriodHours int64
+}
+
+func (e *emulator) increaseRetention(body []byte) (interface{}, error) {
+	var in retentionInput
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	stream, err := e.stream(in.StreamName)
+	if err != nil {
+		return nil, err
+	}
+	if in.RetentionPeriodHours < stream.RetentionHours || in.RetentionPeriodHours > maxRetentionHours {
+		return nil, invalidArgument("RetentionPeriodHours must be between %d and %d", stream.RetentionHours, maxRetentionHours)
+	}
+	stream.RetentionHours = in.RetentionPeriodHours
+	e.dirty = true
+	return struct{}{}, nil
+}
+
+func (e *emulator) decreaseRetention(body []byte) (interface{}, error) {
+	var in retentionInput
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	stream, err := e.stream(in.StreamName)
+	if err != nil {
+		return nil, err
+	}
+	if in.RetentionPeriodHours > stream.RetentionHours || in.RetentionPeriodHours < 24 {
+		return nil, invalidArgument("RetentionPeriodHours must be between 24 and %d", stream.RetentionHours)
+	}
+	stream.RetentionHours = in.RetentionPeriodHours
+	e.dirty = true
+	return struct{}{}, nil
+}
+
+func (e *emulator) addTags(body []byte) (interface{}, error) {
+	var in struct {
+		StreamName string
+		Tags       map[string]string
+	}
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	stream, err := e.stream(in.StreamName)
+	if err != nil {
+		return nil, err
+	}
+	if len(in.Tags) > maxTagsPerCall {
+		return nil, invalidArgument("At most %d tags can be added at once", maxTagsPerCall)
+	}
+	for key, value := range in.Tags {
+		stream.Tags[key] = value
+	}
+	e.dirty = true
+	return struct{}{}, nil
+}
+
+func (e *emulator) removeTags(body []byte) (interface{}, error) {
+	var in struct {
+		StreamName string
+		TagKeys    []string
+	}
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	stream, err := e.stream(in.StreamName)
+	if err != nil {
+		return nil, err
+	}
+	if len(in.TagKeys) > maxTagsPerCall {
+		return nil, invalidArgument("At most %d tags can be removed at once", maxTagsPerCall)
+	}
+	for _, key := range in.TagKeys {
+		delete(stream.Tags, key)
+	}
+	e.dirty = true
+	return struct{}{}, nil
+}
+
+func (e *emulator) listTags(body []byte) (interface{}, error) {
+	var in struct {
+		StreamName           string
+		ExclusiveStartTagKey string
+		Limit                int
+	}
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	stream, err := e.stream(in.StreamName)
+	if err != nil {
+		return nil, err
+	}
+	if in.Limit < 1 || in.Limit > 10 {
+		in.Limit = 10
+	}
+	var keys []string
+	for key := range stream.Tags {
+		if key > in.ExclusiveStartTagKey {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	hasMore := len(keys) > in.Limit
+	if hasMore {
+		keys = keys[:in.Limit]
+	}
+	tags := []map[string]string{}
+	for _, key := range keys {
+		tags = append(tags, map[string]string{"Key": key, "Value": stream.Tags[key]})
+	}
+	return map[string]interface{}{"Tags": tags, "HasMoreTags": hasMore}, nil
+}
+
+// deliver appends records to the file named after the delivery stream, the
+// way Firehose concatenates records into S3 objects.
+func (e *emulator) deliver(name string, records [][]byte) ([]map[string]string, error) {
+	if e.outDir == "" {
+		return nil, &emulatorError{"ResourceNotFoundException", "Firehose is disabled, run c2k emulate with -firehose-dir or -data"}
+	}
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return nil, invalidArgument("Invalid DeliveryStreamName %q", name)
+	}
+	if err := os.MkdirAll(e.outDir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(e.outDir, name), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	responses := make([]map[string]string, len(records))
+	for i, data := range records {
+		if _, err := f.Write(data); err != nil {
+			return nil, err
+		}
+		responses[i] = map[string]string{"RecordId": formatSequence(e.nextSequence())}
+	}
+	return responses, nil
+}
+
+func (e *emulator) putFirehoseRecord(body []byte) (interface{}, error) {
+	var in struct {
+		DeliveryStreamName string
+		Record             struct{ Data []byte }
+	}
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	responses, err := e.deliver(in.DeliveryStreamName, [][]byte{in.Record.Data})
+	if err != nil {
+		return nil, err
+	}
+	return responses[0], nil
+}
+
+func (e *emulator) putFirehoseRecordBatch(body []byte) (interface{}, error) {
+	var in struct {
+		DeliveryStreamName string
+		Records            []struct{ Data []byte }
+	}
+	if err := decodeInput(body, &in); err != nil {
+		return nil, err
+	}
+	if len(in.Records) < 1 || len(in.Records) > MaxPutIdx+1 {
+		return nil, invalidArgument("PutRecordBatch takes 1 to 500 records")
+	}
+	records := make([][]byte, len(in.Records))
+	for i, record := range in.Records {
+		records[i] = record.Data
+	}
+	responses, err := e.deliver(in.DeliveryStreamName, records)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"FailedPutCount": 0, "RequestResponses": responses}, nil
+}
+
+func runEmulate(cmd *command, args []string) {
+	fs := cmd.flagSet()
+	var host, dataDir, outDir string
+	var port int
+	fs.StringVar(&host, "host", "localhost", "Address to listen on, e.g. 0.0.0.0 to accept connections from containers")
+	fs.IntVar(&port, "port", 4567, "Port to listen on")
+	fs.StringVar(&dataDir, "data", "", "Directory to keep streams in across restarts, otherwise they are only kept in memory")
+	fs.StringVar(&outDir, "firehose-dir", "", "Directory to write records put into delivery streams to, defaults to firehose in -data")
+	fs.Parse(args)
+	var path string
+	if dataDir != "" {
+		if err := os.MkdirAll(dataDir, 0755); err != nil {
+			log.Fatal(err)
+		}
+		path = filepath.Join(dataDir, emulatorStateFile)
+		if outDir == "" {
+			outDir = filepath.Join(dataDir, "firehose")
+		}
+	}
+	e, err := newEmulator(path, outDir)
+	if err != nil {
+		log.Fatal("Could not load streams: ", err)
+	}
+	if path != "" {
+		go func() {
+			for range time.Tick(time.Second) {
+				if err := e.save(); err != nil {
+					log.Print("Could not save streams: ", err)
+				}
+			}
+		}()
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-c
+			if err := e.save(); err != nil {
+				log.Fatal("Could not save streams: ", err)
+			}
+			os.Exit(0)
+		}()
+	}
+	addr := fmt.Sprintf("%s:%d", host, port)
+	log.Printf("Emulating Kinesis and Firehose on http://%s", addr)
+	log.Fatal(http.ListenAndServe(addr, e))
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/firehose"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"io/ioutil"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestEmulator serves an emulator saving its streams to path, if any, and
+// returns a Kinesis client for it.
+func newTestEmulator(t *testing.T, path, outDir string) (*emulator, *httptest.Server, *kinesis.Kinesis) {
+	e, err := newEmulator(path, outDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(e)
+	return e, server, newTestService(server.URL)
+}
+
+func createTestStream(t *testing.T, svc *kinesis.Kinesis, name string, shards int64) {
+	if _, err := svc.CreateStream(&kinesis.CreateStreamInput{StreamName: &name, ShardCount: &shards}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func putTestRecords(t *testing.T, svc *kinesis.Kinesis, name string, keys ...string) {
+	var entries []*kinesis.PutRecordsRequestEntry
+	for _, key := range keys {
+		entries = append(entries, &kinesis.PutRecordsRequestEntry{PartitionKey: aws.String(key), Data: []byte("data-" + key)})
+	}
+	if _, err := svc.PutRecords(&kinesis.PutRecordsInput{StreamName: &name, Records: entries}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEmulatorReshardAndBackup(t *testing.T) {
+	_, server, svc := newTestEmulator(t, "", "")
+	defer server.Close()
+	createTestStream(t, svc, "clicks", 2)
+	if err := waitForStream(svc, "clicks", kinesis.StreamStatusActive, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for i := 0; i < 40; i++ {
+		keys = append(keys, fmt.Sprint("key-", i))
+	}
+	putTestRecords(t, svc, "clicks", keys[:20]...)
+	current, err := openHashRanges(getShardIds(svc, "clicks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range planReshard(current, 3) {
+		if err := executeStep(svc, "clicks", step); err != nil {
+			t.Fatalf("%s: %s", step, err)
+		}
+	}
+	putTestRecords(t, svc, "clicks", keys[20:]...)
+
+	shards := getShardIds(svc, "clicks")
+	if open, _ := openHashRanges(shards); len(open) != 3 {
+		t.Fatalf("Expected 3 open shards, got %d", len(open))
+	}
+	dir, err := ioutil.TempDir("", "c2k-emulate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	manifest, err := backupStream(svc, Options{StreamName: "clicks"}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shardsById := make(map[string]*kinesis.Shard)
+	for _, shard := range shards {
+		shardsById[*shard.ShardId] = shard
+	}
+	seen := make(map[string]bool)
+	for _, ms := range manifest.Shards {
+		var last *big.Int
+		f, err := os.Open(filepath.Join(dir, ms.File))
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var record struct{ PartitionKey, SequenceNumber string }
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				t.Fatal(err)
+			}
+			seen[record.PartitionKey] = true
+			if !shardContainsHash(shardsById[ms.ShardId], partitionKeyHash(record.PartitionKey)) {
+				t.Errorf("Record with key %s put into %s", record.PartitionKey, ms.ShardId)
+			}
+			seq, _ := new(big.Int).SetString(record.SequenceNumber, 10)
+			if last != nil && seq.Cmp(last) <= 0 {
+				t.Errorf("Sequence numbers of %s do not increase: %s after %s", ms.ShardId, seq, last)
+			}
+			last = seq
+		}
+		f.Close()
+	}
+	if len(seen) != len(keys) {
+		t.Errorf("Expected %d records in the backup, got %d", len(keys), len(seen))
+	}
+}
+
+func TestEmulatorIterators(t *testing.T) {
+	e, server, svc := newTestEmulator(t, "", "")
+	defer server.Close()
+	now := time.Unix(1000, 0)
+	e.now = func() time.Time { return now }
+	createTestStream(t, svc, "clicks", 1)
+	var seqs []string
+	for i := 0; i < 3; i++ {
+		out, err := svc.PutRecord(&kinesis.PutRecordInput{StreamName: aws.String("clicks"), PartitionKey: aws.String("a"), Data: []byte{byte(i)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		seqs = append(seqs, *out.SequenceNumber)
+		now = now.Add(time.Minute)
+	}
+
+	read := func(itr *string) []byte {
+		out, err := svc.GetRecords(&kinesis.GetRecordsInput{ShardIterator: itr})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var data []byte
+		for _, record := range out.Records {
+			data = append(data, record.Data...)
+		}
+		return data
+	}
+	shardId := "shardId-000000000000"
+	cases := []struct {
+		itrType, seq string
+		expected     []byte
+	}{
+		{TrimHorizon, "", []byte{0, 1, 2}},
+		{Latest, "", nil},
+		{AtSequenceNum, seqs[1], []byte{1, 2}},
+		{AfterSequenceNum, seqs[1], []byte{2}},
+	}
+	for _, c := range cases {
+		in := &kinesis.GetShardIteratorInput{StreamName: aws.String("clicks"), ShardId: &shardId, ShardIteratorType: aws.String(c.itrType)}
+		if c.seq != "" {
+			in.StartingSequenceNumber = &c.seq
+		}
+		out, err := svc.GetShardIterator(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data := read(out.ShardIterator); string(data) != string(c.expected) {
+			t.Errorf("%s %s: expected %v, got %v", c.itrType, c.seq, c.expected, data)
+		}
+	}
+	out, err := svc.GetShardIterator(&kinesis.GetShardIteratorInput{StreamName: aws.String("clicks"), ShardId: &shardId, ShardIteratorType: aws.String(AtTimestamp), Timestamp: aws.Time(time.Unix(1060, 0))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := read(out.ShardIterator); string(data) != string([]byte{1, 2}) {
+		t.Errorf("AT_TIMESTAMP: expected [1 2], got %v", data)
+	}
+
+	if _, err := svc.DeleteStream(&kinesis.DeleteStreamInput{StreamName: aws.String("clicks")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.DescribeStream(&kinesis.DescribeStreamInput{StreamName: aws.String("clicks")}); !isResourceNotFound(err) {
+		t.Errorf("Expected a deleted stream to be missing, got %v", err)
+	}
+}
+
+func TestEmulatorPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "c2k-emulate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, emulatorStateFile)
+
+	e, server, svc := newTestEmulator(t, path, "")
+	createTestStream(t, svc, "clicks", 2)
+	putTestRecords(t, svc, "clicks", "a", "b", "c")
+	if err := e.save(); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	_, server, svc = newTestEmulator(t, path, "")
+	defer server.Close()
+	putTestRecords(t, svc, "clicks", "d")
+	info, err := describeStream(svc, "clicks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(info.Shards) != 2 {
+		t.Fatalf("Expected 2 shards after a restart, got %d", len(info.Shards))
+	}
+	records := 0
+	var last string
+	for _, shard := range info.Shards {
+		out, err := svc.GetShardIterator(&kinesis.GetShardIteratorInput{StreamName: aws.String("clicks"), ShardId: &shard.ShardId, ShardIteratorType: aws.String(TrimHorizon)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		recs, err := svc.GetRecords(&kinesis.GetRecordsInput{ShardIterator: out.ShardIterator})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, record := range recs.Records {
+			records++
+			if *record.PartitionKey == "d" {
+				last = *record.SequenceNumber
+			}
+		}
+	}
+	if records != 4 {
+		t.Errorf("Expected 4 records after a restart, got %d", records)
+	}
+	if last != formatSequence(6) {
+		t.Errorf("Expected sequence numbers to carry on after a restart, got %s", last)
+	}
+}
+
+func TestEmulatorFirehose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "c2k-emulate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	_, server, _ := newTestEmulator(t, "", dir)
+	defer server.Close()
+	fsvc := firehose.New(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	})
+	for _, batch := range [][]string{{"one\n", "two\n"}, {"three\n"}} {
+		input := &firehose.PutRecordBatchInput{DeliveryStreamName: aws.String("logs")}
+		for _, data := range batch {
+			input.Records = append(input.Records, &firehose.Record{Data: []byte(data)})
+		}
+		out, err := fsvc.PutRecordBatch(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *out.FailedPutCount != 0 || len(out.RequestResponses) != len(batch) {
+			t.Errorf("Unexpected response %v", out)
+		}
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "logs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "one\ntwo\nthree\n" {
+		t.Errorf("Unexpected delivered data %q", data)
+	}
+}
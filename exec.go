@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// execSink runs a shell command for every record, or for every batch of
+// records from the same shard. The records are written to the command's
+// stdin in the listener's output format, raw unless -output or -format says
+// otherwise, and described by C2K_* environment variables. Records are only
+// acked once the command exits 0. Any other exit stops the whole listener
+// through fail, leaving every shard's checkpoint before its unacked records.
+type execSink struct {
+	command    string
+	streamName string
+	formatter  recordFormatter
+	slots      chan struct{}
+	fail       func(error)
+	batcher    *shardBatcher
+}
+
+func newExecSink(opts Options, formatter recordFormatter, fail func(error)) *execSink {
+	s := &execSink{
+		command:    opts.Exec,
+		streamName: opts.StreamName,
+		formatter:  formatter,
+		slots:      make(chan struct{}, opts.ExecConcurrency),
+		fail:       fail,
+	}
+	s.batcher = newShardBatcher(opts.ExecBatch, opts.ExecBatchWindow, s.dispatch)
+	return s
+}
+
+func (s *execSink) Write(record *shardRecord) error {
+	s.batcher.add(record)
+	return nil
+}
+
+// dispatch blocks until one of the concurrency slots is free, which holds
+// back the listener while every slot is busy.
+func (s *execSink) dispatch(records []*shardRecord) {
+	s.slots <- struct{}{}
+	go func() {
+		defer func() { <-s.slots }()
+		if err := s.run(records); err != nil {
+			s.fail(err)
+			return
+		}
+		for _, record := range records {
+			record.Ack()
+		}
+	}()
+}
+
+func (s *execSink) run(records []*shardRecord) error {
+	var stdin bytes.Buffer
+	for _, record := range records {
+		if err := s.formatter.Format(&stdin, record); err != nil {
+			return err
+		}
+	}
+	first, last := records[0], records[len(records)-1]
+	cmd := exec.Command("sh", "-c", s.command)
+	cmd.Stdin = &stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"C2K_STREAM_NAME="+s.streamName,
+		"C2K_SHARD_ID="+first.ShardId,
+		"C2K_SEQUENCE_NUMBER="+aws.StringValue(last.SequenceNumber),
+		"C2K_FIRST_SEQUENCE_NUMBER="+aws.StringValue(first.SequenceNumber),
+		"C2K_BATCH_SIZE="+strconv.Itoa(len(records)),
+	)
+	if len(records) == 1 {
+		cmd.Env = append(cmd.Env, "C2K_PARTITION_KEY="+aws.StringValue(first.PartitionKey))
+		if first.ApproximateArrivalTimestamp != nil {
+			cmd.Env = append(cmd.Env, "C2K_ARRIVAL_TIMESTAMP="+first.ApproximateArrivalTimestamp.Format(time.RFC3339Nano))
+		}
+	}
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed for shard %s sequence numbers %s to %s: %s", s.command, first.ShardId,
+			aws.StringValue(first.SequenceNumber), aws.StringValue(last.SequenceNumber), err)
+	}
+	return nil
+}
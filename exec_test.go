@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExecSinkAcksOnSuccess(t *testing.T) {
+	dir, err := ioutil.TempDir("", "c2k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+
+	acked := make(chan string, 2)
+	opts := Options{
+		Exec:            `cat >> ` + out + ` && echo "$C2K_SHARD_ID $C2K_FIRST_SEQUENCE_NUMBER $C2K_SEQUENCE_NUMBER $C2K_BATCH_SIZE" >> ` + out,
+		StreamName:      "stream",
+		ExecBatch:       2,
+		ExecBatchWindow: time.Hour,
+		ExecConcurrency: 1,
+	}
+	s := newExecSink(opts, &rawFormatter{separator: separators["newline"]}, func(err error) { t.Error(err) })
+	for _, seq := range []string{"1", "2"} {
+		seq := seq
+		s.Write(&shardRecord{ShardId: "shardId-0", Record: &kinesis.Record{
+			SequenceNumber: aws.String(seq), Data: []byte("record " + seq),
+		}, ack: func() { acked <- seq }})
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-acked:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Records were not acked")
+		}
+	}
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "record 1\nrecord 2\nshardId-0 1 2 2\n" {
+		t.Errorf("Unexpected command input %q", data)
+	}
+}
+
+func TestExecSinkFailsWithoutAck(t *testing.T) {
+	failed := make(chan error, 1)
+	opts := Options{Exec: "exit 3", ExecBatch: 1, ExecBatchWindow: time.Second, ExecConcurrency: 1}
+	s := newExecSink(opts, &rawFormatter{}, func(err error) { failed <- err })
+	s.Write(&shardRecord{ShardId: "shardId-0", Record: &kinesis.Record{SequenceNumber: aws.String("1")},
+		ack: func() { failed <- errors.New("acked") }})
+	select {
+	case err := <-failed:
+		if err.Error() == "acked" {
+			t.Fatal("Record acked although the command failed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Command failure was not reported")
+	}
+}
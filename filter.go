@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// stringList is a flag.Value collecting every use of a repeatable flag.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// recordFilter decides which records the listener passes on. A record must
+// satisfy every configured condition.
+type recordFilter struct {
+	match        *regexp.Regexp
+	partitionKey string
+	where        []*wherePredicate
+}
+
+// newRecordFilter returns nil when opts configure no filtering.
+func newRecordFilter(opts Options) (*recordFilter, error) {
+	if opts.Match == "" && opts.FilterPartitionKey == "" && len(opts.Where) == 0 {
+		return nil, nil
+	}
+	f := &recordFilter{partitionKey: opts.FilterPartitionKey}
+	if opts.Match != "" {
+		re, err := regexp.Compile(opts.Match)
+		if err != nil {
+			return nil, err
+		}
+		f.match = re
+	}
+	for _, expr := range opts.Where {
+		pred, err := parseWhere(expr)
+		if err != nil {
+			return nil, err
+		}
+		f.where = append(f.where, pred)
+	}
+	return f, nil
+}
+
+func (f *recordFilter) Match(record *kinesis.Record) bool {
+	if f.partitionKey != "" && aws.StringValue(record.PartitionKey) != f.partitionKey {
+		return false
+	}
+	if f.match != nil && !f.match.Match(record.Data) {
+		return false
+	}
+	if len(f.where) == 0 {
+		return true
+	}
+	var doc interface{}
+	if err := json.Unmarshal(record.Data, &doc); err != nil {
+		return false
+	}
+	for _, pred := range f.where {
+		if !pred.eval(doc) {
+			return false
+		}
+	}
+	return true
+}
+
+// wherePredicate compares a field of a JSON record, e.g. .level == "ERROR".
+type wherePredicate struct {
+	path  []string
+	op    string
+	value interface{}
+	re    *regexp.Regexp
+}
+
+var whereExpr = regexp.MustCompile(`^\s*(\.[^\s=!<>~]*)\s*(==|!=|<=|>=|<|>|=~)\s*(.+?)\s*$`)
+
+func parseWhere(expr string) (*wherePredicate, error) {
+	m := whereExpr.FindStringSubmatch(expr)
+	if m == nil {
+		return nil, fmt.Errorf("invalid where expression %q, expected .field OP value", expr)
+	}
+	pred := &wherePredicate{op: m[2]}
+	for _, part := range strings.Split(m[1], ".") {
+		if part != "" {
+			pred.path = append(pred.path, part)
+		}
+	}
+	if err := json.Unmarshal([]byte(m[3]), &pred.value); err != nil {
+		return nil, fmt.Errorf("invalid value in where expression %q: %s", expr, err)
+	}
+	if pred.op == "=~" {
+		pattern, ok := pred.value.(string)
+		if !ok {
+			return nil, fmt.Errorf("where expression %q must match against a string", expr)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		pred.re = re
+	}
+	return pred, nil
+}
+
+// lookup follows path through objects and arrays in doc.
+func lookup(doc interface{}, path []string) (interface{}, bool) {
+	for _, part := range path {
+		switch v := doc.(type) {
+		case map[string]interface{}:
+			child, ok := v[part]
+			if !ok {
+				return nil, false
+			}
+			doc = child
+		case []interface{}:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			doc = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+func (p *wherePredicate) eval(doc interface{}) bool {
+	field, ok := lookup(doc, p.path)
+	if !ok {
+		return p.op == "!="
+	}
+	switch p.op {
+	case "==":
+		return reflect.DeepEqual(field, p.value)
+	case "!=":
+		return !reflect.DeepEqual(field, p.value)
+	case "=~":
+		s, ok := field.(string)
+		return ok && p.re.MatchString(s)
+	}
+	cmp, ok := compare(field, p.value)
+	if !ok {
+		return false
+	}
+	switch p.op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+// compare orders two numbers or two strings.
+func compare(a, b interface{}) (int, bool) {
+	switch av := a.(type) {
+	case float64:
+		bv, ok := b.(float64)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case av < bv:
+			return -1, true
+		case av > bv:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		bv, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(av, bv), true
+	}
+	return 0, false
+}
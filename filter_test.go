@@ -0,0 +1,79 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"testing"
+)
+
+func TestRecordFilter(t *testing.T) {
+	f, err := newRecordFilter(Options{
+		Match:              "timeout",
+		FilterPartitionKey: "cust-123",
+		Where:              stringList{`.level == "ERROR"`, `.http.status >= 500`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		key, data string
+		expected  bool
+	}{
+		{"cust-123", `{"level":"ERROR","http":{"status":504},"msg":"timeout"}`, true},
+		{"cust-456", `{"level":"ERROR","http":{"status":504},"msg":"timeout"}`, false},
+		{"cust-123", `{"level":"INFO","http":{"status":504},"msg":"timeout"}`, false},
+		{"cust-123", `{"level":"ERROR","http":{"status":404},"msg":"timeout"}`, false},
+		{"cust-123", `{"level":"ERROR","http":{"status":504},"msg":"refused"}`, false},
+		{"cust-123", `timeout, not json`, false},
+	}
+	for _, c := range cases {
+		record := &kinesis.Record{PartitionKey: aws.String(c.key), Data: []byte(c.data)}
+		if f.Match(record) != c.expected {
+			t.Errorf("Match(%s, %s) should be %t", c.key, c.data, c.expected)
+		}
+	}
+}
+
+func TestNoFilter(t *testing.T) {
+	f, err := newRecordFilter(Options{})
+	if f != nil || err != nil {
+		t.Error("Expected no filter without filter options")
+	}
+}
+
+func TestWherePredicates(t *testing.T) {
+	doc := map[string]interface{}{
+		"level": "WARN",
+		"tags":  []interface{}{"a", "b"},
+		"n":     float64(3),
+	}
+	cases := map[string]bool{
+		`.level == "WARN"`:  true,
+		`.level != "WARN"`:  false,
+		`.level =~ "^W"`:    true,
+		`.tags.1 == "b"`:    true,
+		`.n < 4`:            true,
+		`.n > 4`:            false,
+		`.missing != null`:  true,
+		`.missing == null`:  false,
+		`.level > 3`:        false,
+		`.level >= "ERROR"`: true,
+	}
+	for expr, expected := range cases {
+		pred, err := parseWhere(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pred.eval(doc) != expected {
+			t.Errorf("%s should be %t", expr, expected)
+		}
+	}
+}
+
+func TestParseWhereErrors(t *testing.T) {
+	for _, expr := range []string{`level == "x"`, `.level == ERROR`, `.level =~ 3`, `.level`} {
+		if _, err := parseWhere(expr); err == nil {
+			t.Errorf("Expected %s to be rejected", expr)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/firehose"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// maxUpdateAttempts is how often update-destination reads the version ID
+// and tries again when another update gets in between.
+const maxUpdateAttempts = 3
+
+// destinationSpec is the file given to c2k firehose create and
+// update-destination. Its fields are named as in the Firehose API, so
+// create takes the same JSON as the AWS CLI.
+type destinationSpec struct {
+	S3DestinationConfiguration       *firehose.S3DestinationConfiguration
+	RedshiftDestinationConfiguration *firehose.RedshiftDestinationConfiguration
+	S3DestinationUpdate              *firehose.S3DestinationUpdate
+	RedshiftDestinationUpdate        *firehose.RedshiftDestinationUpdate
+}
+
+// parseDestinationSpec reads a spec written in JSON or YAML. YAML is
+// converted to JSON first so both are decoded by the same field names.
+func parseDestinationSpec(data []byte) (*destinationSpec, error) {
+	data = bytes.TrimSpace(data)
+	if !bytes.HasPrefix(data, []byte("{")) {
+		var v interface{}
+		if err := yaml.Unmarshal(data, &v); err != nil {
+			return nil, err
+		}
+		var err error
+		if data, err = json.Marshal(jsonCompatible(v)); err != nil {
+			return nil, err
+		}
+	}
+	var spec destinationSpec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, err
+	}
+	return &spec, nil
+}
+
+// jsonCompatible turns the map[interface{}]interface{} values YAML decodes
+// into map[string]interface{} so they can be marshalled as JSON.
+func jsonCompatible(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = jsonCompatible(value)
+		}
+		return m
+	case []interface{}:
+		for i, value := range v {
+			v[i] = jsonCompatible(value)
+		}
+	}
+	return v
+}
+
+func readDestinationSpec(path string) (*destinationSpec, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseDestinationSpec(data)
+}
+
+func isConcurrentModification(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == "ConcurrentModificationException"
+}
+
+// describeDeliveryStream reads the description of a delivery stream with
+// all of its destinations.
+func describeDeliveryStream(fsvc *firehose.Firehose, name string) (*firehose.DeliveryStreamDescription, error) {
+	input := &firehose.DescribeDeliveryStreamInput{DeliveryStreamName: &name}
+	var desc *firehose.DeliveryStreamDescription
+	for {
+		out, err := fsvc.DescribeDeliveryStream(input)
+		if err != nil {
+			return nil, err
+		}
+		page := out.DeliveryStreamDescription
+		if desc == nil {
+			desc = page
+		} else {
+			desc.Destinations = append(desc.Destinations, page.Destinations...)
+		}
+		if !aws.BoolValue(page.HasMoreDestinations) || len(page.Destinations) == 0 {
+			desc.HasMoreDestinations = aws.Bool(false)
+			return desc, nil
+		}
+		input.ExclusiveStartDestinationId = page.Destinations[len(page.Destinations)-1].DestinationId
+	}
+}
+
+// waitForDeliveryStream polls the delivery stream until its status is
+// status, or until it no longer exists when status is empty.
+func waitForDeliveryStream(fsvc *firehose.Firehose, name, status string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		out, err := fsvc.DescribeDeliveryStream(&firehose.DescribeDeliveryStreamInput{DeliveryStreamName: &name, Limit: aws.Int64(1)})
+		if status == "" && isResourceNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		current := aws.StringValue(out.DeliveryStreamDescription.DeliveryStreamStatus)
+		if current == status {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("delivery stream %s is still %s after %s", name, current, timeout)
+		}
+		time.Sleep(streamPollInterval)
+	}
+}
+
+func runFirehoseList(cmd *command, args []string) {
+	fs := cmd.flagSet()
+	var opts Options
+	var output string
+	addAWSFlags(fs, &opts)
+	addOutputFlag(fs, &output)
+	fs.Parse(args)
+	checkOutput(output)
+	fsvc := createFirehoseService(opts)
+	names := []string{}
+	input := &firehose.ListDeliveryStreamsInput{}
+	for {
+		out, err := fsvc.ListDeliveryStreams(input)
+		if err != nil {
+			log.Fatal("Could not list delivery streams: ", err)
+		}
+		names = append(names, aws.StringValueSlice(out.DeliveryStreamNames)...)
+		if !aws.BoolValue(out.HasMoreDeliveryStreams) || len(out.DeliveryStreamNames) == 0 {
+			break
+		}
+		input.ExclusiveStartDeliveryStreamName = out.DeliveryStreamNames[len(out.DeliveryStreamNames)-1]
+	}
+	if output == jsonOutput {
+		writeJSON(os.Stdout, names)
+		return
+	}
+	for _, name := range names {
+		fmt.Println(name)
+	}
+}
+
+func runFirehoseDescribe(cmd *command, args []string) {
+	fs := cmd.flagSet()
+	var opts Options
+	var output string
+	addStreamFlags(fs, &opts)
+	addOutputFlag(fs, &output)
+	fs.Parse(args)
+	requireStreamName(opts)
+	checkOutput(output)
+	desc, err := describeDeliveryStream(createFirehoseService(opts), opts.StreamName)
+	if err != nil {
+		log.Fatal("Could not describe delivery stream: ", err)
+	}
+	if output == jsonOutput {
+		writeJSON(os.Stdout, desc)
+		return
+	}
+	writeDeliveryStreamTable(os.Stdout, desc)
+}
+
+func writeDeliveryStreamTable(w io.Writer, desc *firehose.DeliveryStreamDescription) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Delivery stream:\t%s\n", aws.StringValue(desc.DeliveryStreamName))
+	fmt.Fprintf(tw, "ARN:\t%s\n", aws.StringValue(desc.DeliveryStreamARN))
+	fmt.Fprintf(tw, "Status:\t%s\n", aws.StringValue(desc.DeliveryStreamStatus))
+	fmt.Fprintf(tw, "Version ID:\t%s\n", aws.StringValue(desc.VersionId))
+	if desc.CreateTimestamp != nil {
+		fmt.Fprintf(tw, "Created:\t%s\n", desc.CreateTimestamp.UTC().Format(time.RFC3339))
+	}
+	if desc.LastUpdateTimestamp != nil {
+		fmt.Fprintf(tw, "Updated:\t%s\n", desc.LastUpdateTimestamp.UTC().Format(time.RFC3339))
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "DESTINATION\tTYPE\tTARGET\tBUCKET\tPREFIX\tBUFFER\tCOMPRESSION")
+	for _, dest := range desc.Destinations {
+		kind, target, s3 := "S3", "-", dest.S3DestinationDescription
+		if r := dest.RedshiftDestinationDescription; r != nil {
+			kind, target, s3 = "Redshift", aws.StringValue(r.ClusterJDBCURL), r.S3DestinationDescription
+			if r.CopyCommand != nil {
+				target += " " + aws.StringValue(r.CopyCommand.DataTableName)
+			}
+		}
+		bucket, prefix, buffer, compression := "-", "-", "-", "-"
+		if s3 != nil {
+			bucket, prefix, compression = aws.StringValue(s3.BucketARN), dashIfEmpty(aws.StringValue(s3.Prefix)), aws.StringValue(s3.CompressionFormat)
+			if h := s3.BufferingHints; h != nil {
+				buffer = fmt.Sprintf("%dMB/%ds", aws.Int64Value(h.SizeInMBs), aws.Int64Value(h.IntervalInSeconds))
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", aws.StringValue(dest.DestinationId), kind, target, bucket, prefix, buffer, compression)
+	}
+	tw.Flush()
+}
+
+func runFirehoseCreate(cmd *command, args []string) {
+	fs := cmd.flagSet()
+	var opts Options
+	var specPath string
+	var wait bool
+	var timeout time.Duration
+	addStreamFlags(fs, &opts)
+	fs.StringVar(&specPath, "spec", "", "JSON or YAML file with an S3DestinationConfiguration or RedshiftDestinationConfiguration")
+	fs.BoolVar(&wait, "wait", false, "Wait until the delivery stream is ACTIVE")
+	fs.DurationVar(&timeout, "timeout", 10*time.Minute, "Longest to wait for the delivery stream")
+	fs.Parse(args)
+	requireStreamName(opts)
+	if specPath == "" {
+		log.Fatal("create requires -spec")
+	}
+	spec, err := readDestinationSpec(specPath)
+	if err != nil {
+		log.Fatal("Could not read destination spec: ", err)
+	}
+	if spec.S3DestinationConfiguration == nil && spec.RedshiftDestinationConfiguration == nil {
+		log.Fatal("The destination spec needs an S3DestinationConfiguration or RedshiftDestinationConfiguration")
+	}
+	fsvc := createFirehoseService(opts)
+	_, err = fsvc.CreateDeliveryStream(&firehose.CreateDeliveryStreamInput{
+		DeliveryStreamName:               &opts.StreamName,
+		S3DestinationConfiguration:       spec.S3DestinationConfiguration,
+		RedshiftDestinationConfiguration: spec.RedshiftDestinationConfiguration,
+	})
+	if err != nil {
+		log.Fatal("Could not create delivery stream: ", err)
+	}
+	log.Printf("Creating delivery stream %s", opts.StreamName)
+	if !wait {
+		return
+	}
+	if err := waitForDeliveryStream(fsvc, opts.StreamName, firehose.DeliveryStreamStatusActive, timeout); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Delivery stream %s is ACTIVE", opts.StreamName)
+}
+
+func runFirehoseDelete(cmd *command, args []string) {
+	fs := cmd.flagSet()
+	var opts Options
+	var wait bool
+	var timeout time.Duration
+	addStreamFlags(fs, &opts)
+	fs.BoolVar(&wait, "wait", false, "Wait until the delivery stream is gone")
+	fs.DurationVar(&timeout, "timeout", 10*time.Minute, "Longest to wait for the delivery stream")
+	fs.Parse(args)
+	requireStreamName(opts)
+	fsvc := createFirehoseService(opts)
+	if _, err := fsvc.DeleteDeliveryStream(&firehose.DeleteDeliveryStreamInput{DeliveryStreamName: &opts.StreamName}); err != nil {
+		log.Fatal("Could not delete delivery stream: ", err)
+	}
+	log.Printf("Deleting delivery stream %s", opts.StreamName)
+	if !wait {
+		return
+	}
+	if err := waitForDeliveryStream(fsvc, opts.StreamName, "", timeout); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Delivery stream %s is deleted", opts.StreamName)
+}
+
+// updateDestination applies spec to a destination of a delivery stream.
+// With a versionId the update only succeeds if nobody changed the delivery
+// stream since that version was read. Without one the current version is
+// read, and read again should another update get in between.
+func updateDestination(fsvc *firehose.Firehose, name, destinationId, versionId string, spec *destinationSpec) error {
+	for attempt := 1; ; attempt++ {
+		input := &firehose.UpdateDestinationInput{
+			DeliveryStreamName:             &name,
+			DestinationId:                  aws.String(destinationId),
+			CurrentDeliveryStreamVersionId: aws.String(versionId),
+			S3DestinationUpdate:            spec.S3DestinationUpdate,
+			RedshiftDestinationUpdate:      spec.RedshiftDestinationUpdate,
+		}
+		pinned := versionId != ""
+		if !pinned || destinationId == "" {
+			desc, err := describeDeliveryStream(fsvc, name)
+			if err != nil {
+				return err
+			}
+			if !pinned {
+				input.CurrentDeliveryStreamVersionId = desc.VersionId
+			}
+			if destinationId == "" {
+				if len(desc.Destinations) != 1 {
+					return fmt.Errorf("delivery stream %s has %d destinations, choose one with -destination-id", name, len(desc.Destinations))
+				}
+				input.DestinationId = desc.Destinations[0].DestinationId
+			}
+		}
+		_, err := fsvc.UpdateDestination(input)
+		if !isConcurrentModification(err) {
+			return err
+		}
+		if pinned {
+			return fmt.Errorf("delivery stream %s changed since version %s, describe it again before updating: %s", name, versionId, err)
+		}
+		if attempt == maxUpdateAttempts {
+			return err
+		}
+		log.Printf("Delivery stream %s changed while updating, trying again", name)
+	}
+}
+
+func runFirehoseUpdateDestination(cmd *command, args []string) {
+	fs := cmd.flagSet()
+	var opts Options
+	var specPath, destinationId, versionId string
+	addStreamFlags(fs, &opts)
+	fs.StringVar(&specPath, "spec", "", "JSON or YAML file with an S3DestinationUpdate or RedshiftDestinationUpdate")
+	fs.StringVar(&destinationId, "destination-id", "", "Destination to update, needed when there is more than one")
+	fs.StringVar(&versionId, "version-id", "", "Only update if the delivery stream is still at this version ID")
+	fs.Parse(args)
+	requireStreamName(opts)
+	if specPath == "" {
+		log.Fatal("update-destination requires -spec")
+	}
+	spec, err := readDestinationSpec(specPath)
+	if err != nil {
+		log.Fatal("Could not read destination spec: ", err)
+	}
+	if spec.S3DestinationUpdate == nil && spec.RedshiftDestinationUpdate == nil {
+		log.Fatal("The destination spec needs an S3DestinationUpdate or RedshiftDestinationUpdate")
+	}
+	fsvc := createFirehoseService(opts)
+	if err := updateDestination(fsvc, opts.StreamName, destinationId, versionId, spec); err != nil {
+		log.Fatal("Could not update destination: ", err)
+	}
+	log.Printf("Updated the destination of %s", opts.StreamName)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/firehose"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDestinationSpec(t *testing.T) {
+	jsonSpec := `{
+	"S3DestinationConfiguration": {
+		"RoleARN": "arn:aws:iam::123456789012:role/firehose",
+		"BucketARN": "arn:aws:s3:::logs",
+		"Prefix": "clicks/",
+		"BufferingHints": {"SizeInMBs": 5, "IntervalInSeconds": 300},
+		"CompressionFormat": "GZIP"
+	}
+}`
+	yamlSpec := `
+S3DestinationConfiguration:
+  RoleARN: arn:aws:iam::123456789012:role/firehose
+  BucketARN: arn:aws:s3:::logs
+  Prefix: clicks/
+  BufferingHints:
+    SizeInMBs: 5
+    IntervalInSeconds: 300
+  CompressionFormat: GZIP
+`
+	fromJSON, err := parseDestinationSpec([]byte(jsonSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromYAML, err := parseDestinationSpec([]byte(yamlSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s3 := fromYAML.S3DestinationConfiguration
+	if s3 == nil || aws.StringValue(s3.BucketARN) != "arn:aws:s3:::logs" || aws.Int64Value(s3.BufferingHints.IntervalInSeconds) != 300 {
+		t.Fatalf("Unexpected spec from YAML %v", fromYAML)
+	}
+	if !reflect.DeepEqual(fromJSON, fromYAML) {
+		t.Errorf("JSON and YAML specs differ:\n%v\n%v", fromJSON.S3DestinationConfiguration, s3)
+	}
+}
+
+func TestUpdateDestinationVersionId(t *testing.T) {
+	var versions []string
+	conflicts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		switch strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "Firehose_20150804.") {
+		case "DescribeDeliveryStream":
+			w.Write([]byte(`{"DeliveryStreamDescription":{"DeliveryStreamName":"logs","DeliveryStreamARN":"arn","DeliveryStreamStatus":"ACTIVE",` +
+				`"VersionId":"` + string('1'+byte(len(versions))) + `","HasMoreDestinations":false,"Destinations":[{"DestinationId":"destinationId-000000000001"}]}}`))
+		case "UpdateDestination":
+			var in struct{ CurrentDeliveryStreamVersionId, DestinationId string }
+			json.Unmarshal(body, &in)
+			versions = append(versions, in.CurrentDeliveryStreamVersionId)
+			if in.DestinationId != "destinationId-000000000001" {
+				t.Errorf("Unexpected destination %s", in.DestinationId)
+			}
+			if conflicts > 0 {
+				conflicts--
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"__type":"ConcurrentModificationException","message":"version mismatch"}`))
+				return
+			}
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+
+	fsvc := firehose.New(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	})
+	spec := &destinationSpec{S3DestinationUpdate: &firehose.S3DestinationUpdate{Prefix: aws.String("new/")}}
+
+	conflicts = 1
+	if err := updateDestination(fsvc, "logs", "", "", spec); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(versions, []string{"1", "2"}) {
+		t.Errorf("Expected the version to be read again after a conflict, got %v", versions)
+	}
+
+	versions, conflicts = nil, 1
+	err := updateDestination(fsvc, "logs", "destinationId-000000000001", "7", spec)
+	if err == nil || !strings.Contains(err.Error(), "changed since version 7") {
+		t.Errorf("Expected a conflict error for a pinned version, got %v", err)
+	}
+	if !reflect.DeepEqual(versions, []string{"7"}) {
+		t.Errorf("Expected one attempt at version 7, got %v", versions)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/md5"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"math/big"
+)
+
+// partitionKeyHash maps a partition key to its 128-bit hash key the same way
+// Kinesis does: the MD5 digest read as an unsigned big-endian integer.
+func partitionKeyHash(key string) *big.Int {
+	sum := md5.Sum([]byte(key))
+	return new(big.Int).SetBytes(sum[:])
+}
+
+func shardContainsHash(shard *kinesis.Shard, hash *big.Int) bool {
+	if shard.HashKeyRange == nil {
+		return false
+	}
+	start, ok := new(big.Int).SetString(aws.StringValue(shard.HashKeyRange.StartingHashKey), 10)
+	if !ok {
+		return false
+	}
+	end, ok := new(big.Int).SetString(aws.StringValue(shard.HashKeyRange.EndingHashKey), 10)
+	if !ok {
+		return false
+	}
+	return start.Cmp(hash) <= 0 && hash.Cmp(end) <= 0
+}
+
+func isChildOf(shard *kinesis.Shard, parentId string) bool {
+	return aws.StringValue(shard.ParentShardId) == parentId || aws.StringValue(shard.AdjacentParentShardId) == parentId
+}
+
+// shardsForHash returns the lineage of shards whose hash key range contains
+// hash, oldest first. At any time exactly one open shard owns a hash key, so
+// the matching shards form a single chain of parents and children.
+func shardsForHash(shards []*kinesis.Shard, hash *big.Int) []*kinesis.Shard {
+	var owners []*kinesis.Shard
+	ids := make(map[string]bool)
+	for _, shard := range shards {
+		if shardContainsHash(shard, hash) {
+			owners = append(owners, shard)
+			ids[*shard.ShardId] = true
+		}
+	}
+	var chain []*kinesis.Shard
+	for _, shard := range owners {
+		if !ids[aws.StringValue(shard.ParentShardId)] && !ids[aws.StringValue(shard.AdjacentParentShardId)] {
+			chain = append(chain, shard)
+			break
+		}
+	}
+	for len(chain) > 0 && len(chain) < len(owners) {
+		last := *chain[len(chain)-1].ShardId
+		var next *kinesis.Shard
+		for _, shard := range owners {
+			if isChildOf(shard, last) {
+				next = shard
+				break
+			}
+		}
+		if next == nil {
+			break
+		}
+		chain = append(chain, next)
+	}
+	return chain
+}
+
+// childForHash returns the shard that took over hash from parentId, if any.
+func childForHash(shards []*kinesis.Shard, parentId string, hash *big.Int) *kinesis.Shard {
+	for _, shard := range shards {
+		if isChildOf(shard, parentId) && shardContainsHash(shard, hash) {
+			return shard
+		}
+	}
+	return nil
+}
+
+// hashKeySpace is the number of hash keys, 2^128.
+var hashKeySpace = new(big.Int).Lsh(big.NewInt(1), 128)
+
+// hashRangeShare returns the fraction of all hash keys that shard owns.
+func hashRangeShare(shard *kinesis.Shard) float64 {
+	if shard.HashKeyRange == nil {
+		return 0
+	}
+	start, ok1 := new(big.Int).SetString(aws.StringValue(shard.HashKeyRange.StartingHashKey), 10)
+	end, ok2 := new(big.Int).SetString(aws.StringValue(shard.HashKeyRange.EndingHashKey), 10)
+	if !ok1 || !ok2 {
+		return 0
+	}
+	size := new(big.Int).Sub(end, start)
+	size.Add(size, big.NewInt(1))
+	share, _ := new(big.Rat).SetFrac(size, hashKeySpace).Float64()
+	return share
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"testing"
+)
+
+const (
+	maxHashKey  = "340282366920938463463374607431768211455"
+	halfHashKey = "170141183460469231731687303715884105728"
+)
+
+func testShard(id, parent, adjacent, start, end string) *kinesis.Shard {
+	shard := &kinesis.Shard{
+		ShardId:      aws.String(id),
+		HashKeyRange: &kinesis.HashKeyRange{StartingHashKey: aws.String(start), EndingHashKey: aws.String(end)},
+	}
+	if parent != "" {
+		shard.ParentShardId = aws.String(parent)
+	}
+	if adjacent != "" {
+		shard.AdjacentParentShardId = aws.String(adjacent)
+	}
+	return shard
+}
+
+func TestPartitionKeyHash(t *testing.T) {
+	if h := partitionKeyHash("cust-123").String(); h != "338068717084340260258376741924849257559" {
+		t.Errorf("Unexpected hash key %s", h)
+	}
+}
+
+func TestShardsForHashFollowsLineage(t *testing.T) {
+	// shard 0 was split into 1 and 2, then 1 and 2 were merged into 3.
+	shards := []*kinesis.Shard{
+		testShard("shardId-3", "shardId-1", "shardId-2", "0", maxHashKey),
+		testShard("shardId-2", "shardId-0", "", halfHashKey, maxHashKey),
+		testShard("shardId-0", "", "", "0", maxHashKey),
+		testShard("shardId-1", "shardId-0", "", "0", "170141183460469231731687303715884105727"),
+	}
+	chain := shardsForHash(shards, partitionKeyHash("cust-123"))
+	expected := []string{"shardId-0", "shardId-2", "shardId-3"}
+	if len(chain) != len(expected) {
+		t.Fatalf("Expected %v but got %d shards", expected, len(chain))
+	}
+	for i, shard := range chain {
+		if *shard.ShardId != expected[i] {
+			t.Errorf("Expected %s at %d but got %s", expected[i], i, *shard.ShardId)
+		}
+	}
+	if child := childForHash(shards, "shardId-2", partitionKeyHash("cust-123")); child == nil || *child.ShardId != "shardId-3" {
+		t.Errorf("Expected shardId-3 to take over from shardId-2")
+	}
+	if child := childForHash(shards, "shardId-3", partitionKeyHash("cust-123")); child != nil {
+		t.Errorf("Open shard should have no child")
+	}
+}
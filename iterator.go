@@ -0,0 +1,89 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"log"
+	"time"
+)
+
+// atTimestampUnsupported reports whether err is the service rejecting the
+// AT_TIMESTAMP iterator type as unknown, as older Kinesis stand-ins do. They
+// refuse the request shape, which is reported as a ValidationException or a
+// SerializationException. Kinesis reports a timestamp it cannot serve as an
+// InvalidArgumentException, which is a real error.
+func atTimestampUnsupported(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+	switch aerr.Code() {
+	case "ValidationException", "SerializationException":
+		return true
+	}
+	return false
+}
+
+// isIteratorExpired reports whether err is GetRecords refusing an iterator
+// that was not used within five minutes.
+func isIteratorExpired(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == "ExpiredIteratorException"
+}
+
+// afterSequenceIterator returns an iterator for shardId just after seq.
+func (l *Listener) afterSequenceIterator(shardId, seq string) (*string, error) {
+	afterSeq := AfterSequenceNum
+	out, err := l.svc.GetShardIterator(&kinesis.GetShardIteratorInput{ShardIteratorType: &afterSeq, StartingSequenceNumber: &seq, ShardId: &shardId, StreamName: &l.opts.StreamName})
+	if err != nil {
+		return nil, err
+	}
+	return out.ShardIterator, nil
+}
+
+// shardIterator returns the first iterator for shardId according to the
+// listener options, or at TRIM_HORIZON when fromStart is set. When a start
+// time was requested but AT_TIMESTAMP is not supported, it falls back to
+// TRIM_HORIZON and returns the time before which records must be skipped;
+// otherwise the returned time is zero. A checkpoint for the shard takes
+// precedence over all of these.
+func (l *Listener) shardIterator(shardId string, fromStart bool) (*string, time.Time, error) {
+	if seq := l.checkpoints.get(shardId); seq != "" {
+		shardIterator, err := l.afterSequenceIterator(shardId, seq)
+		return shardIterator, time.Time{}, err
+	}
+	if fromStart {
+		trimHorizon := TrimHorizon
+		out, err := l.svc.GetShardIterator(&kinesis.GetShardIteratorInput{ShardIteratorType: &trimHorizon, ShardId: &shardId, StreamName: &l.opts.StreamName})
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		return out.ShardIterator, time.Time{}, nil
+	}
+	if !l.opts.StartingTimestamp.IsZero() {
+		atTimestamp := AtTimestamp
+		out, err := l.svc.GetShardIterator(&kinesis.GetShardIteratorInput{ShardIteratorType: &atTimestamp, Timestamp: &l.opts.StartingTimestamp, ShardId: &shardId, StreamName: &l.opts.StreamName})
+		if err == nil {
+			return out.ShardIterator, time.Time{}, nil
+		}
+		if !atTimestampUnsupported(err) {
+			return nil, time.Time{}, err
+		}
+		log.Printf("AT_TIMESTAMP not supported for shard %s, skipping forward from %s: %s", shardId, TrimHorizon, err)
+		trimHorizon := TrimHorizon
+		out, err = l.svc.GetShardIterator(&kinesis.GetShardIteratorInput{ShardIteratorType: &trimHorizon, ShardId: &shardId, StreamName: &l.opts.StreamName})
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		return out.ShardIterator, l.opts.StartingTimestamp, nil
+	}
+	input := &kinesis.GetShardIteratorInput{ShardIteratorType: &l.opts.ItrType, ShardId: &shardId, StreamName: &l.opts.StreamName}
+	if l.opts.StartingSeqNum != "" {
+		input.StartingSequenceNumber = &l.opts.StartingSeqNum
+	}
+	out, err := l.svc.GetShardIterator(input)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return out.ShardIterator, time.Time{}, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAtTimestampUnsupported(t *testing.T) {
+	cases := []struct {
+		err      error
+		expected bool
+	}{
+		{awserr.New("ValidationException", "1 validation error detected: Value 'AT_TIMESTAMP' at 'shardIteratorType' failed to satisfy constraint", nil), true},
+		{awserr.New("SerializationException", "Unknown ShardIteratorType", nil), true},
+		{awserr.New("InvalidArgumentException", "Invalid ShardIteratorType AT_TIMESTAMP", nil), false},
+		{awserr.New("InvalidArgumentException", "The timestamp must not be in the future", nil), false},
+		{awserr.New("ResourceNotFoundException", "Stream missing not found", nil), false},
+		{errors.New("ValidationException"), false},
+	}
+	for _, c := range cases {
+		if actual := atTimestampUnsupported(c.err); actual != c.expected {
+			t.Errorf("%v: expected %v, got %v", c.err, c.expected, actual)
+		}
+	}
+}
+
+// iteratorServer answers GetShardIterator with the requested type as the
+// iterator, rejecting AT_TIMESTAMP with the rejectAtTimestamp error code if
+// set, and records each request as "TYPE", "TYPE seq" or "TYPE unixtime".
+type iteratorServer struct {
+	*httptest.Server
+	rejectAtTimestamp string
+	requests          []string
+}
+
+func newIteratorServer(rejectAtTimestamp string, getRecords http.HandlerFunc) *iteratorServer {
+	s := &iteratorServer{rejectAtTimestamp: rejectAtTimestamp}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.Header.Get("X-Amz-Target"), "GetShardIterator") {
+			getRecords(w, r)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		var in struct {
+			ShardIteratorType, StartingSequenceNumber string
+			Timestamp                                 int64
+		}
+		json.Unmarshal(body, &in)
+		request := in.ShardIteratorType
+		if in.StartingSequenceNumber != "" {
+			request += " " + in.StartingSequenceNumber
+		}
+		if in.Timestamp != 0 {
+			request += fmt.Sprintf(" %d", in.Timestamp)
+		}
+		s.requests = append(s.requests, request)
+		if in.ShardIteratorType == AtTimestamp && s.rejectAtTimestamp != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"__type": s.rejectAtTimestamp, "message": "rejected"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"ShardIterator": in.ShardIteratorType})
+	}))
+	return s
+}
+
+func TestShardIteratorPrecedence(t *testing.T) {
+	since := time.Unix(1545084650, 0)
+	atSince := fmt.Sprintf("%s %d", AtTimestamp, since.Unix())
+	cases := []struct {
+		name              string
+		checkpoint        string
+		fromStart         bool
+		since             time.Time
+		rejectAtTimestamp string
+		requests          []string
+		skipUntil         time.Time
+		fails             bool
+	}{
+		{name: "checkpoint first", checkpoint: "7", fromStart: true, since: since, requests: []string{AfterSequenceNum + " 7"}},
+		{name: "then from start", fromStart: true, since: since, requests: []string{TrimHorizon}},
+		{name: "then timestamp", since: since, requests: []string{atSince}},
+		{name: "timestamp unsupported", since: since, rejectAtTimestamp: "SerializationException", requests: []string{atSince, TrimHorizon}, skipUntil: since},
+		{name: "timestamp invalid", since: since, rejectAtTimestamp: "InvalidArgumentException", requests: []string{atSince}, fails: true},
+		{name: "then options", requests: []string{AtSequenceNum + " 5"}},
+	}
+	for _, c := range cases {
+		server := newIteratorServer(c.rejectAtTimestamp, nil)
+		l := &Listener{
+			opts: Options{StreamName: "precedence", ItrType: AtSequenceNum, StartingSeqNum: "5", StartingTimestamp: c.since},
+			svc:  newTestService(server.URL),
+		}
+		if c.checkpoint != "" {
+			l.checkpoints = &checkpointer{file: checkpointFile{Shards: map[string]string{"shardId-000000000000": c.checkpoint}}}
+		}
+		_, skipUntil, err := l.shardIterator("shardId-000000000000", c.fromStart)
+		server.Close()
+		if (err != nil) != c.fails {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		}
+		if !reflect.DeepEqual(server.requests, c.requests) {
+			t.Errorf("%s: expected requests %q, got %q", c.name, c.requests, server.requests)
+		}
+		if !skipUntil.Equal(c.skipUntil) {
+			t.Errorf("%s: expected to skip until %v, got %v", c.name, c.skipUntil, skipUntil)
+		}
+	}
+}
+
+func TestFollowIteratorSkipsToStartingTimestamp(t *testing.T) {
+	since := time.Unix(1545084650, 0)
+	record := func(seq string, arrival time.Time) map[string]interface{} {
+		return map[string]interface{}{"SequenceNumber": seq, "PartitionKey": "k", "Data": []byte(seq), "ApproximateArrivalTimestamp": arrival.Unix()}
+	}
+	// Each iterator names the next; the shard closes after "third".
+	batches := map[string]struct {
+		records []map[string]interface{}
+		next    string
+	}{
+		TrimHorizon: {[]map[string]interface{}{record("1", since.Add(-2*time.Second)), record("2", since.Add(-time.Second))}, "second"},
+		// The first record at the start time opens the batch; once a
+		// record is delivered, older ones after it are no longer skipped.
+		"second": {[]map[string]interface{}{record("3", since), record("4", since.Add(time.Second)), record("5", since.Add(-5*time.Second))}, "third"},
+		"third":  {[]map[string]interface{}{record("6", since.Add(-10*time.Second))}, ""},
+	}
+	server := newIteratorServer("SerializationException", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var in struct{ ShardIterator string }
+		json.Unmarshal(body, &in)
+		batch := batches[in.ShardIterator]
+		out := map[string]interface{}{"Records": batch.records, "MillisBehindLatest": 0}
+		if batch.next != "" {
+			out["NextShardIterator"] = batch.next
+		}
+		json.NewEncoder(w).Encode(out)
+	})
+	defer server.Close()
+
+	l := &Listener{
+		opts:  Options{StreamName: "skipping", ItrType: TrimHorizon, StartingTimestamp: since, MinPoll: time.Millisecond, MaxPoll: time.Millisecond},
+		svc:   newTestService(server.URL),
+		stats: newListenStats(time.Now()),
+	}
+	records := make(chan *shardRecord, 10)
+	l.followIterator("shardId-000000000000", false, records)
+	close(records)
+	var read []string
+	for r := range records {
+		read = append(read, *r.Record.SequenceNumber)
+	}
+	if expected := []string{"3", "4", "5", "6"}; !reflect.DeepEqual(read, expected) {
+		t.Errorf("Expected records %v, got %v", expected, read)
+	}
+}
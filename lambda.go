@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// lambdaEvent is the event a Kinesis event source mapping invokes Lambda with.
+type lambdaEvent struct {
+	Records []lambdaRecord `json:"Records"`
+}
+
+type lambdaRecord struct {
+	Kinesis           lambdaKinesisData `json:"kinesis"`
+	EventSource       string            `json:"eventSource"`
+	EventVersion      string            `json:"eventVersion"`
+	EventID           string            `json:"eventID"`
+	EventName         string            `json:"eventName"`
+	InvokeIdentityArn string            `json:"invokeIdentityArn"`
+	AwsRegion         string            `json:"awsRegion"`
+	EventSourceARN    string            `json:"eventSourceARN"`
+}
+
+type lambdaKinesisData struct {
+	KinesisSchemaVersion        string  `json:"kinesisSchemaVersion"`
+	PartitionKey                string  `json:"partitionKey"`
+	SequenceNumber              string  `json:"sequenceNumber"`
+	Data                        string  `json:"data"`
+	ApproximateArrivalTimestamp float64 `json:"approximateArrivalTimestamp"`
+}
+
+// lambdaResponse picks out the parts of a handler's response that signal
+// failure: a function error, or a partial batch response.
+type lambdaResponse struct {
+	ErrorMessage      string `json:"errorMessage"`
+	ErrorType         string `json:"errorType"`
+	BatchItemFailures []struct {
+		ItemIdentifier string `json:"itemIdentifier"`
+	} `json:"batchItemFailures"`
+}
+
+// lambdaSink emulates a Kinesis event source mapping. Records are grouped
+// per shard into Lambda Kinesis events and handed to a local Runtime
+// Interface Emulator URL or command. Like the real mapping, each shard has
+// at most one batch in flight and a failed batch is retried, holding up the
+// shard, until it succeeds or the retries are used up.
+type lambdaSink struct {
+	url, command string
+	streamARN    string
+	region       string
+	retries      int
+	batcher      *shardBatcher
+}
+
+func newLambdaSink(opts Options, streamARN string) *lambdaSink {
+	s := &lambdaSink{
+		url:       opts.LambdaURL,
+		command:   opts.LambdaExec,
+		streamARN: streamARN,
+		region:    opts.Region,
+		retries:   opts.LambdaRetries,
+	}
+	queues := newShardQueues(s.invokeWithRetries)
+	s.batcher = newShardBatcher(opts.LambdaBatch, opts.LambdaBatchWindow, queues.enqueue)
+	return s
+}
+
+func (s *lambdaSink) Write(record *shardRecord) error {
+	s.batcher.add(record)
+	return nil
+}
+
+func (s *lambdaSink) invokeWithRetries(records []*shardRecord) {
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		failedAt, err := s.invoke(records)
+		if err == nil {
+			for _, record := range records {
+				record.Ack()
+			}
+			return
+		}
+		// Records before the first reported failure are done.
+		for _, record := range records[:failedAt] {
+			record.Ack()
+		}
+		records = records[failedAt:]
+		if s.retries >= 0 && attempt >= s.retries {
+			log.Printf("Dropping %d records from shard %s after %d retries: %s", len(records), records[0].ShardId, attempt, err)
+			for _, record := range records {
+				record.Ack()
+			}
+			return
+		}
+		log.Printf("Lambda invocation failed for shard %s, retrying in %s: %s", records[0].ShardId, backoff, err)
+		time.Sleep(backoff)
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+func (s *lambdaSink) event(records []*shardRecord) *lambdaEvent {
+	account := "000000000000"
+	if parts := strings.Split(s.streamARN, ":"); len(parts) > 4 && parts[4] != "" {
+		account = parts[4]
+	}
+	event := &lambdaEvent{Records: make([]lambdaRecord, len(records))}
+	for i, record := range records {
+		var arrival float64
+		if record.ApproximateArrivalTimestamp != nil {
+			arrival = float64(record.ApproximateArrivalTimestamp.UnixNano()) / 1e9
+		}
+		seq := aws.StringValue(record.SequenceNumber)
+		event.Records[i] = lambdaRecord{
+			Kinesis: lambdaKinesisData{
+				KinesisSchemaVersion:        "1.0",
+				PartitionKey:                aws.StringValue(record.PartitionKey),
+				SequenceNumber:              seq,
+				Data:                        base64.StdEncoding.EncodeToString(record.Data),
+				ApproximateArrivalTimestamp: arrival,
+			},
+			EventSource:       "aws:kinesis",
+			EventVersion:      "1.0",
+			EventID:           record.ShardId + ":" + seq,
+			EventName:         "aws:kinesis:record",
+			InvokeIdentityArn: "arn:aws:iam::" + account + ":role/c2k",
+			AwsRegion:         s.region,
+			EventSourceARN:    s.streamARN,
+		}
+	}
+	return event
+}
+
+// invoke sends one batch to the handler. On failure it returns the index of
+// the first record that has to be retried.
+func (s *lambdaSink) invoke(records []*shardRecord) (int, error) {
+	payload, err := json.Marshal(s.event(records))
+	if err != nil {
+		return 0, err
+	}
+	var body []byte
+	if s.url != "" {
+		resp, err := http.Post(s.url, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			return 0, err
+		}
+		defer resp.Body.Close()
+		body, err = ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return 0, err
+		}
+		if resp.StatusCode/100 != 2 {
+			return 0, fmt.Errorf("%s: %s", resp.Status, body)
+		}
+	} else {
+		var stdout bytes.Buffer
+		cmd := exec.Command("sh", "-c", s.command)
+		cmd.Stdin = bytes.NewReader(payload)
+		cmd.Stdout = &stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return 0, err
+		}
+		body = stdout.Bytes()
+	}
+	return checkLambdaResponse(records, body)
+}
+
+func checkLambdaResponse(records []*shardRecord, body []byte) (int, error) {
+	var resp lambdaResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		// Handlers may return anything; only a JSON object can signal failure.
+		return 0, nil
+	}
+	if resp.ErrorType != "" || resp.ErrorMessage != "" {
+		return 0, fmt.Errorf("%s: %s", resp.ErrorType, resp.ErrorMessage)
+	}
+	if len(resp.BatchItemFailures) == 0 {
+		return 0, nil
+	}
+	failed := make(map[string]bool)
+	for _, failure := range resp.BatchItemFailures {
+		failed[failure.ItemIdentifier] = true
+	}
+	for i, record := range records {
+		if failed[aws.StringValue(record.SequenceNumber)] {
+			return i, fmt.Errorf("batch item failure at sequence number %s", aws.StringValue(record.SequenceNumber))
+		}
+	}
+	return 0, fmt.Errorf("batch item failure for unknown sequence number")
+}
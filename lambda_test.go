@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func lambdaTestRecords() []*shardRecord {
+	arrival := time.Unix(1545084650, 987000000)
+	var records []*shardRecord
+	for _, seq := range []string{"101", "102", "103"} {
+		records = append(records, &shardRecord{ShardId: "shardId-000000000006", Record: &kinesis.Record{
+			SequenceNumber: aws.String(seq), PartitionKey: aws.String("1"), Data: []byte("Hello"),
+			ApproximateArrivalTimestamp: &arrival,
+		}})
+	}
+	return records
+}
+
+func TestLambdaSinkPostsKinesisEvent(t *testing.T) {
+	var event lambdaEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Error(err)
+		}
+		w.Write([]byte(`null`))
+	}))
+	defer server.Close()
+
+	s := newLambdaSink(Options{LambdaURL: server.URL, Region: "us-east-2", LambdaBatch: 100, LambdaBatchWindow: time.Second},
+		"arn:aws:kinesis:us-east-2:123456789012:stream/lambda-stream")
+	records := lambdaTestRecords()
+	if _, err := s.invoke(records); err != nil {
+		t.Fatal(err)
+	}
+	if len(event.Records) != 3 {
+		t.Fatalf("Expected 3 records in the event but got %d", len(event.Records))
+	}
+	r := event.Records[0]
+	if r.Kinesis.Data != "SGVsbG8=" || r.Kinesis.SequenceNumber != "101" || r.Kinesis.ApproximateArrivalTimestamp != 1545084650.987 {
+		t.Errorf("Unexpected kinesis data %+v", r.Kinesis)
+	}
+	if r.EventID != "shardId-000000000006:101" || r.EventSourceARN != "arn:aws:kinesis:us-east-2:123456789012:stream/lambda-stream" ||
+		r.InvokeIdentityArn != "arn:aws:iam::123456789012:role/c2k" || r.EventName != "aws:kinesis:record" || r.AwsRegion != "us-east-2" {
+		t.Errorf("Unexpected event record %+v", r)
+	}
+}
+
+func TestCheckLambdaResponse(t *testing.T) {
+	records := lambdaTestRecords()
+	cases := []struct {
+		body     string
+		failedAt int
+		failed   bool
+	}{
+		{`null`, 0, false},
+		{`"ok"`, 0, false},
+		{`{"batchItemFailures":[]}`, 0, false},
+		{`{"errorMessage":"boom","errorType":"Error"}`, 0, true},
+		{`{"batchItemFailures":[{"itemIdentifier":"103"},{"itemIdentifier":"102"}]}`, 1, true},
+	}
+	for _, c := range cases {
+		failedAt, err := checkLambdaResponse(records, []byte(c.body))
+		if (err != nil) != c.failed || failedAt != c.failedAt {
+			t.Errorf("%s: expected failure %t at %d but got %v at %d", c.body, c.failed, c.failedAt, err, failedAt)
+		}
+	}
+}
+
+func TestLambdaSinkDropsAfterRetries(t *testing.T) {
+	s := newLambdaSink(Options{LambdaExec: `echo '{"errorType":"Error"}'`, LambdaRetries: 0, LambdaBatch: 1, LambdaBatchWindow: time.Second}, "")
+	acked := 0
+	records := lambdaTestRecords()[:1]
+	records[0].ack = func() { acked++ }
+	s.invokeWithRetries(records)
+	if acked != 1 {
+		t.Error("Expected the batch to be dropped and acked once retries are used up")
+	}
+}
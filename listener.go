@@ -1,8 +1,8 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/kinesis"
 	"io"
 	"log"
@@ -11,13 +11,34 @@ import (
 	"time"
 )
 
+// recordBufferSize bounds how many records the shard readers may queue ahead
+// of the output writer. Once it is full, readers block until the writer
+// catches up, so a slow consumer of stdout back-pressures GetRecords calls.
+const recordBufferSize = 1000
+
 type Listener struct {
-	opts Options
-	svc  *kinesis.Kinesis
+	opts        Options
+	svc         *kinesis.Kinesis
+	formatter   recordFormatter
+	merger      *arrivalMerger
+	stats       *listenStats
+	filter      *recordFilter
+	checkpoints *checkpointer
 }
 
 func NewListener(opts Options, svc *kinesis.Kinesis) *Listener {
-	return &Listener{opts: opts, svc: svc}
+	filter, err := newRecordFilter(opts)
+	if err != nil {
+		log.Fatal("Invalid filter: ", err)
+	}
+	l := &Listener{opts: opts, svc: svc, formatter: newFormatter(opts), stats: newListenStats(time.Now()), filter: filter}
+	if opts.Checkpoint != "" {
+		l.checkpoints, err = loadCheckpointer(opts.Checkpoint, opts.StreamName)
+		if err != nil {
+			log.Fatal("Could not load checkpoint: ", err)
+		}
+	}
+	return l
 }
 
 func getShardIds(svc *kinesis.Kinesis, streamName string) []*kinesis.Shard {
@@ -39,15 +60,57 @@ func getShardIds(svc *kinesis.Kinesis, streamName string) []*kinesis.Shard {
 	return shards
 }
 
+func getStreamARN(svc *kinesis.Kinesis, streamName string) string {
+	out, err := svc.DescribeStream(&kinesis.DescribeStreamInput{StreamName: &streamName, Limit: aws.Int64(1)})
+	if err != nil {
+		log.Fatal("Could not describe stream to find its ARN: ", err)
+	}
+	return *out.StreamDescription.StreamARN
+}
+
 func (l *Listener) Listen(wrtr io.Writer) {
+	var sink recordSink = &writerSink{w: wrtr, formatter: l.formatter}
+	if l.opts.Exec != "" {
+		sink = newExecSink(l.opts, l.formatter, l.fail)
+	} else if l.opts.LambdaURL != "" || l.opts.LambdaExec != "" {
+		sink = newLambdaSink(l.opts, getStreamARN(l.svc, l.opts.StreamName))
+	} else if l.opts.OutDir != "" {
+		sink = newArchiveSink(l.opts, l.formatter, l.fail)
+	}
+	l.listenTo(sink)
+}
+
+// listenTo delivers the records of the stream to sink until a signal is
+// received.
+func (l *Listener) listenTo(sink recordSink) {
 	var shards []*kinesis.Shard
-	if l.opts.ShardId == defaultShardId {
+	if l.opts.ForKey != "" {
+		shards = shardsForHash(getShardIds(l.svc, l.opts.StreamName), partitionKeyHash(l.opts.ForKey))
+		if len(shards) == 0 {
+			log.Fatal("No shard found for partition key ", l.opts.ForKey)
+		}
+	} else if l.opts.ShardId == defaultShardId {
 		shards = getShardIds(l.svc, l.opts.StreamName)
 	} else {
 		shards = []*kinesis.Shard{&kinesis.Shard{ShardId: &l.opts.ShardId}}
 	}
-	for _, shard := range shards {
-		go l.followIterator(*shard.ShardId, wrtr)
+	records := make(chan *shardRecord, recordBufferSize)
+	if l.opts.MergeOrder == MergeArrival {
+		shardIds := make([]string, len(shards))
+		for i, shard := range shards {
+			shardIds[i] = *shard.ShardId
+		}
+		l.merger = newArrivalMerger(shardIds, l.opts.MaxSkew)
+		go l.merger.run(records)
+	}
+	if l.opts.ForKey != "" {
+		go l.followKey(shards, records)
+	} else {
+		l.followShards(shards, records)
+	}
+	go l.writeOutput(records, sink)
+	if l.opts.LagReport > 0 {
+		go l.reportLag(os.Stderr)
 	}
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, os.Kill)
@@ -55,54 +118,178 @@ func (l *Listener) Listen(wrtr io.Writer) {
 	// Block until a signal is received.
 	s := <-c
 	log.Printf("Received signal: %s", s)
+	l.shutdown(sink, os.Stderr)
+}
+
+// shutdown flushes sink, saves how far the listener got and writes the
+// summary of the run to w.
+func (l *Listener) shutdown(sink recordSink, w io.Writer) {
+	if closer, ok := sink.(io.Closer); ok {
+		closer.Close()
+	}
+	l.checkpoints.save()
+	l.stats.summary(w, time.Now())
+}
+
+func (l *Listener) reportLag(wrtr io.Writer) {
+	for now := range time.Tick(l.opts.LagReport) {
+		l.stats.report(wrtr, now)
+	}
 }
 
-func (l *Listener) followIterator(shardId string, wrtr io.Writer) {
-	input := &kinesis.GetShardIteratorInput{ShardIteratorType: &l.opts.ItrType, ShardId: &shardId, StreamName: &l.opts.StreamName}
-	if l.opts.StartingSeqNum != "" {
-		input.StartingSequenceNumber = &l.opts.StartingSeqNum
+// fail saves how far the listener got before exiting with err.
+func (l *Listener) fail(err error) {
+	l.checkpoints.save()
+	log.Fatal(err)
+}
+
+// writeOutput is the only goroutine that writes to the sink, so records from
+// different shards never interleave.
+func (l *Listener) writeOutput(records <-chan *shardRecord, sink recordSink) {
+	for record := range records {
+		if err := sink.Write(record); err != nil {
+			l.fail(fmt.Errorf("Error writing record: %s", err))
+		}
+	}
+}
+
+// followShards reads every shard, each only once its parents among shards
+// have been read, so records sharing a partition key come out in order even
+// across resharding.
+func (l *Listener) followShards(shards []*kinesis.Shard, records chan<- *shardRecord) {
+	done := make(map[string]chan struct{})
+	for _, shard := range shards {
+		done[*shard.ShardId] = make(chan struct{})
 	}
-	out, err := l.svc.GetShardIterator(input)
+	for _, shard := range shards {
+		go func(shard *kinesis.Shard) {
+			for _, parentId := range []*string{shard.ParentShardId, shard.AdjacentParentShardId} {
+				if parent, ok := done[aws.StringValue(parentId)]; ok {
+					<-parent
+				}
+			}
+			l.followIterator(*shard.ShardId, false, records)
+			close(done[*shard.ShardId])
+		}(shard)
+	}
+}
+
+// followKey reads the shards that own the -for-key partition key one after
+// the other, moving on to the child shard whenever a shard is closed by
+// resharding. With a LATEST iterator only the open shard is read.
+func (l *Listener) followKey(chain []*kinesis.Shard, records chan<- *shardRecord) {
+	hash := partitionKeyHash(l.opts.ForKey)
+	if l.opts.ItrType == Latest && l.opts.StartingTimestamp.IsZero() {
+		chain = chain[len(chain)-1:]
+	}
+	fromStart := false
+	for {
+		for _, shard := range chain {
+			l.followIterator(*shard.ShardId, fromStart, records)
+			fromStart = true
+		}
+		last := *chain[len(chain)-1].ShardId
+		for {
+			if child := childForHash(getShardIds(l.svc, l.opts.StreamName), last, hash); child != nil {
+				chain = []*kinesis.Shard{child}
+				break
+			}
+			time.Sleep(l.opts.MaxPoll)
+		}
+	}
+}
+
+// followIterator reads shardId until the shard is closed. When fromStart is
+// set the shard is read from TRIM_HORIZON regardless of the listener options,
+// as is needed for a child shard once its parent has been read.
+func (l *Listener) followIterator(shardId string, fromStart bool, records chan<- *shardRecord) {
+	shardIterator, skipUntil, err := l.shardIterator(shardId, fromStart)
 	if err != nil {
-		//TODO: Actually handle this
-		panic(err)
+		l.fail(fmt.Errorf("Error getting iterator for shard %s: %s", shardId, err))
+		return
 	}
-	shardIterator := out.ShardIterator
+	poll := newPollInterval(l.opts.MinPoll, l.opts.MaxPoll)
+	limiter := limiterFor(l.opts.StreamName, shardId)
+	lastSeq := ""
 	for {
-		recordsOut := l.writeRecords(shardIterator, wrtr)
+		recordsOut, err := l.readRecords(shardId, shardIterator, skipUntil, limiter, records)
+		if isThroughputExceeded(err) {
+			log.Printf("Read throughput exceeded for shard %s, backing off", shardId)
+			time.Sleep(poll.backoff())
+			continue
+		}
+		if isIteratorExpired(err) {
+			// A slow sink can hold up a shard past the iterator's five
+			// minutes, so carry on after the last record read.
+			log.Printf("Iterator for shard %s expired, getting a new one", shardId)
+			if lastSeq != "" {
+				shardIterator, err = l.afterSequenceIterator(shardId, lastSeq)
+			} else {
+				shardIterator, skipUntil, err = l.shardIterator(shardId, fromStart)
+			}
+			if err != nil {
+				l.fail(fmt.Errorf("Error getting iterator for shard %s: %s", shardId, err))
+				return
+			}
+			continue
+		}
+		if err != nil {
+			l.fail(fmt.Errorf("Error reading shard %s: %s", shardId, err))
+			return
+		}
+		if n := len(recordsOut.Records); n > 0 {
+			lastSeq = *recordsOut.Records[n-1].SequenceNumber
+		}
 		shardIterator = recordsOut.NextShardIterator
-		// If we are caught up just wait
-		if *recordsOut.MillisBehindLatest == 0 {
-			time.Sleep(5 * time.Second)
+		if shardIterator == nil {
+			log.Printf("Shard %s is closed", shardId)
+			if l.merger != nil {
+				l.merger.finish(shardId)
+			}
+			return
 		}
+		if len(recordsOut.Records) > 0 {
+			skipUntil = time.Time{}
+		}
+		time.Sleep(poll.next(len(recordsOut.Records), *recordsOut.MillisBehindLatest))
 	}
 }
 
-func (l *Listener) writeRecords(shardIterator *string, wrtr io.Writer) (recordsOut *kinesis.GetRecordsOutput) {
+func (l *Listener) readRecords(shardId string, shardIterator *string, skipUntil time.Time, limiter *shardLimiter, records chan<- *shardRecord) (*kinesis.GetRecordsOutput, error) {
+	limiter.wait()
 	getInput := &kinesis.GetRecordsInput{ShardIterator: shardIterator}
 	recordsOut, err := l.svc.GetRecords(getInput)
 	if err != nil {
-		//TODO: Handle it
-		panic(err)
+		return nil, err
 	}
+	size := 0
 	for _, record := range recordsOut.Records {
-		brdr := bufio.NewScanner(bytes.NewReader(record.Data))
-		for brdr.Scan() {
-			err := brdr.Err()
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				log.Fatal("Error reading record data: ", err)
-			}
-			//These lines are for removing blank lines from data payloads
-			data := bytes.TrimSpace(brdr.Bytes())
-			if len(data) == 0 {
-				continue
-			}
-			data = append(data, '\n')
-			wrtr.Write(data)
+		size += len(record.Data)
+	}
+	limiter.consumed(size, time.Now())
+	read := recordsOut.Records
+	// Without AT_TIMESTAMP support we skip forward until the first record at
+	// or after the requested start time. Records carry no index by time, so
+	// this reads the shard's whole retained history before the start time,
+	// at the usual GetRecords limits.
+	for !skipUntil.IsZero() && len(read) > 0 && read[0].ApproximateArrivalTimestamp != nil && read[0].ApproximateArrivalTimestamp.Before(skipUntil) {
+		read = read[1:]
+	}
+	recordsOut.Records = read
+	matched := make([]*shardRecord, 0, len(read))
+	for _, record := range read {
+		if l.filter == nil || l.filter.Match(record) {
+			ack := l.checkpoints.track(shardId, *record.SequenceNumber)
+			matched = append(matched, &shardRecord{ShardId: shardId, Record: record, ack: ack})
 		}
 	}
-	return recordsOut
+	l.stats.update(shardId, read, len(matched), *recordsOut.MillisBehindLatest)
+	if l.merger != nil {
+		l.merger.add(shardId, matched, *recordsOut.MillisBehindLatest)
+		return recordsOut, nil
+	}
+	for _, record := range matched {
+		records <- record
+	}
+	return recordsOut, nil
 }
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFollowIteratorRenewsExpiredIterator(t *testing.T) {
+	var afterSeq string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var in struct{ ShardIterator, ShardIteratorType, StartingSequenceNumber string }
+		json.Unmarshal(body, &in)
+		switch op := r.Header.Get("X-Amz-Target"); {
+		case strings.HasSuffix(op, "GetShardIterator") && in.ShardIteratorType == AfterSequenceNum:
+			afterSeq = in.StartingSequenceNumber
+			json.NewEncoder(w).Encode(map[string]string{"ShardIterator": "renewed"})
+		case strings.HasSuffix(op, "GetShardIterator"):
+			json.NewEncoder(w).Encode(map[string]string{"ShardIterator": "first"})
+		case in.ShardIterator == "first":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"Records":            []map[string]interface{}{{"SequenceNumber": "42", "PartitionKey": "k", "Data": []byte("a")}},
+				"NextShardIterator":  "stale",
+				"MillisBehindLatest": 0,
+			})
+		case in.ShardIterator == "stale":
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"__type": "ExpiredIteratorException", "message": "Iterator expired"})
+		default:
+			// The renewed iterator finds the shard closed.
+			json.NewEncoder(w).Encode(map[string]interface{}{"Records": []interface{}{}, "MillisBehindLatest": 0})
+		}
+	}))
+	defer server.Close()
+
+	l := &Listener{
+		opts:  Options{StreamName: "expiring", ItrType: TrimHorizon, MinPoll: time.Millisecond, MaxPoll: time.Millisecond},
+		svc:   newTestService(server.URL),
+		stats: newListenStats(time.Now()),
+	}
+	records := make(chan *shardRecord, 10)
+	l.followIterator("shardId-000000000000", false, records)
+	if afterSeq != "42" {
+		t.Errorf("Expected a new iterator after sequence number 42, got %q", afterSeq)
+	}
+	if len(records) != 1 {
+		t.Errorf("Expected the record to be read once, got %d", len(records))
+	}
+}
+
+// gateSink holds every Write until release is closed, then passes the
+// record on to a writerSink, noting the order records arrive in and whether
+// any two Writes ever overlapped.
+type gateSink struct {
+	release  chan struct{}
+	sink     *writerSink
+	mu       sync.Mutex
+	inWrite  bool
+	overlap  bool
+	received []*shardRecord
+}
+
+func (s *gateSink) Write(record *shardRecord) error {
+	s.mu.Lock()
+	s.overlap = s.overlap || s.inWrite
+	s.inWrite = true
+	s.mu.Unlock()
+	<-s.release
+	err := s.sink.Write(record)
+	s.mu.Lock()
+	s.inWrite = false
+	s.received = append(s.received, record)
+	s.mu.Unlock()
+	return err
+}
+
+func (s *gateSink) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.received)
+}
+
+func TestWriteOutputFansInShards(t *testing.T) {
+	const shards, batches, batchSize = 3, 2, 600
+	var mu sync.Mutex
+	getRecordsCalls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var in struct{ ShardId, ShardIterator string }
+		json.Unmarshal(body, &in)
+		if strings.HasSuffix(r.Header.Get("X-Amz-Target"), "GetShardIterator") {
+			json.NewEncoder(w).Encode(map[string]string{"ShardIterator": in.ShardId + "/0"})
+			return
+		}
+		mu.Lock()
+		getRecordsCalls++
+		mu.Unlock()
+		// Iterators are "shardId/batch"; every record has two lines.
+		i := strings.LastIndex(in.ShardIterator, "/")
+		shardId := in.ShardIterator[:i]
+		batch, _ := strconv.Atoi(in.ShardIterator[i+1:])
+		records := make([]map[string]interface{}, batchSize)
+		for j := range records {
+			seq := strconv.Itoa(batch*batchSize + j)
+			records[j] = map[string]interface{}{"SequenceNumber": seq, "PartitionKey": "k", "Data": []byte(fmt.Sprintf("%s %s a\n%s %s b\n", shardId, seq, shardId, seq))}
+		}
+		out := map[string]interface{}{"Records": records, "MillisBehindLatest": 0}
+		if batch+1 < batches {
+			out["NextShardIterator"] = fmt.Sprintf("%s/%d", shardId, batch+1)
+		}
+		json.NewEncoder(w).Encode(out)
+	}))
+	defer server.Close()
+	calls := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return getRecordsCalls
+	}
+
+	l := &Listener{
+		opts:  Options{StreamName: "fan-in", ItrType: TrimHorizon, MinPoll: time.Millisecond, MaxPoll: time.Millisecond},
+		svc:   newTestService(server.URL),
+		stats: newListenStats(time.Now()),
+	}
+	var out bytes.Buffer
+	sink := &gateSink{release: make(chan struct{}), sink: &writerSink{w: &out, formatter: &linesFormatter{}}}
+	var shardList []*kinesis.Shard
+	for i := 0; i < shards; i++ {
+		shardList = append(shardList, &kinesis.Shard{ShardId: aws.String(fmt.Sprintf("shardId-%012d", i))})
+	}
+	records := make(chan *shardRecord, recordBufferSize)
+	l.followShards(shardList, records)
+	go l.writeOutput(records, sink)
+
+	// With the sink held up the readers fill the buffer and then stop
+	// reading rather than dropping records or queueing more. Unblocked, they
+	// would make every GetRecords call well within the wait.
+	deadline := time.Now().Add(5 * time.Second)
+	for len(records) < recordBufferSize && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if len(records) != recordBufferSize {
+		t.Fatalf("Expected a full buffer of %d records behind the slow sink, got %d", recordBufferSize, len(records))
+	}
+	if c := calls(); c >= shards*batches {
+		t.Errorf("Expected readers to stop before their last GetRecords call while the sink is blocked, got %d calls", c)
+	}
+
+	close(sink.release)
+	deadline = time.Now().Add(5 * time.Second)
+	for sink.count() < shards*batches*batchSize && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if len(sink.received) != shards*batches*batchSize {
+		t.Fatalf("Expected %d records, got %d", shards*batches*batchSize, len(sink.received))
+	}
+	if sink.overlap {
+		t.Error("Expected the sink to be written by one goroutine at a time")
+	}
+	next := make(map[string]int)
+	for _, record := range sink.received {
+		if seq := *record.Record.SequenceNumber; seq != strconv.Itoa(next[record.ShardId]) {
+			t.Fatalf("Expected %s record %d next, got %s", record.ShardId, next[record.ShardId], seq)
+		}
+		next[record.ShardId]++
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	for i := 0; i+1 < len(lines); i += 2 {
+		if a, b := lines[i], lines[i+1]; !strings.HasSuffix(a, " a") || strings.TrimSuffix(a, " a") != strings.TrimSuffix(b, " b") {
+			t.Fatalf("Expected the lines of a record together, got %q then %q", a, b)
+		}
+	}
+}
+
+type closingSink struct {
+	closed bool
+}
+
+func (s *closingSink) Write(record *shardRecord) error { return nil }
+
+func (s *closingSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestShutdownPrintsSummary(t *testing.T) {
+	l := &Listener{opts: Options{StreamName: "stopping"}, stats: newListenStats(time.Now())}
+	l.stats.update("shardId-000000000000", []*kinesis.Record{{SequenceNumber: aws.String("1"), Data: []byte("a")}}, 1, 0)
+	sink := &closingSink{}
+	var buf bytes.Buffer
+	l.shutdown(sink, &buf)
+	if !sink.closed {
+		t.Error("Expected the sink to be closed")
+	}
+	if !strings.HasPrefix(buf.String(), "Read 1 records") {
+		t.Errorf("Expected a summary without -lag-report, got %q", buf.String())
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"container/heap"
+	"time"
+)
+
+const MergeArrival string = "arrival"
+
+// mergeBatch is what a shard reader hands the merger after each GetRecords
+// call. Sending the records and the caught up state together keeps the
+// shard's watermark from overtaking records still in flight.
+type mergeBatch struct {
+	shardId            string
+	records            []*shardRecord
+	millisBehindLatest int64
+	closed             bool
+}
+
+type mergeItem struct {
+	record   *shardRecord
+	arrival  time.Time
+	received time.Time
+}
+
+// mergeHeap orders records by arrival time, then shard, then sequence number.
+// The SDK reads arrival times to the whole second, so within a second the
+// shard decides the order.
+type mergeHeap []*mergeItem
+
+func (h mergeHeap) Len() int      { return len(h) }
+func (h mergeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h mergeHeap) Less(i, j int) bool {
+	a, b := h[i], h[j]
+	if !a.arrival.Equal(b.arrival) {
+		return a.arrival.Before(b.arrival)
+	}
+	if a.record.ShardId != b.record.ShardId {
+		return a.record.ShardId < b.record.ShardId
+	}
+	return lessSequenceNumber(*a.record.SequenceNumber, *b.record.SequenceNumber)
+}
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.(*mergeItem)) }
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
+
+// lessSequenceNumber compares two decimal sequence numbers numerically.
+func lessSequenceNumber(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return a < b
+}
+
+// arrivalMerger reorders records from all shards by ApproximateArrivalTimestamp.
+// Each shard has a watermark: the latest arrival time it is known to have
+// delivered, or the current time once it is caught up. A buffered record is
+// released when every shard's watermark has passed it, or when it has been
+// held for maxSkew so that a stalled shard cannot block output forever.
+// Shards not known up front, such as children created by resharding, are
+// tracked from their first batch.
+type arrivalMerger struct {
+	maxSkew    time.Duration
+	in         chan *mergeBatch
+	pending    mergeHeap
+	watermarks map[string]time.Time
+}
+
+func newArrivalMerger(shardIds []string, maxSkew time.Duration) *arrivalMerger {
+	m := &arrivalMerger{
+		maxSkew:    maxSkew,
+		in:         make(chan *mergeBatch, len(shardIds)),
+		watermarks: make(map[string]time.Time, len(shardIds)),
+	}
+	for _, shardId := range shardIds {
+		m.watermarks[shardId] = time.Time{}
+	}
+	return m
+}
+
+func (m *arrivalMerger) add(shardId string, records []*shardRecord, millisBehindLatest int64) {
+	m.in <- &mergeBatch{shardId: shardId, records: records, millisBehindLatest: millisBehindLatest}
+}
+
+// finish tells the merger shardId is closed, so its watermark no longer holds
+// back records from the remaining shards.
+func (m *arrivalMerger) finish(shardId string) {
+	m.in <- &mergeBatch{shardId: shardId, closed: true}
+}
+
+func (m *arrivalMerger) run(out chan<- *shardRecord) {
+	tick := m.maxSkew / 4
+	if tick < 10*time.Millisecond {
+		tick = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	emit := func(record *shardRecord) { out <- record }
+	for {
+		select {
+		case batch := <-m.in:
+			m.accept(batch, time.Now())
+		case <-ticker.C:
+		}
+		m.release(time.Now(), emit)
+	}
+}
+
+func (m *arrivalMerger) accept(batch *mergeBatch, now time.Time) {
+	if batch.closed {
+		delete(m.watermarks, batch.shardId)
+		return
+	}
+	watermark := m.watermarks[batch.shardId]
+	for _, record := range batch.records {
+		var arrival time.Time
+		if record.ApproximateArrivalTimestamp != nil {
+			arrival = *record.ApproximateArrivalTimestamp
+		}
+		heap.Push(&m.pending, &mergeItem{
+			record:   record,
+			arrival:  arrival,
+			received: now,
+		})
+		if arrival.After(watermark) {
+			watermark = arrival
+		}
+	}
+	if batch.millisBehindLatest == 0 && now.After(watermark) {
+		watermark = now
+	}
+	m.watermarks[batch.shardId] = watermark
+}
+
+func (m *arrivalMerger) release(now time.Time, emit func(*shardRecord)) {
+	var low time.Time
+	first := true
+	for _, watermark := range m.watermarks {
+		if first || watermark.Before(low) {
+			low, first = watermark, false
+		}
+	}
+	for m.pending.Len() > 0 {
+		top := m.pending[0]
+		if top.arrival.After(low) && now.Sub(top.received) < m.maxSkew {
+			return
+		}
+		heap.Pop(&m.pending)
+		emit(top.record)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"testing"
+	"time"
+)
+
+func arrivalRecord(shardId, seq string, arrival time.Time) *shardRecord {
+	return &shardRecord{ShardId: shardId, Record: &kinesis.Record{SequenceNumber: aws.String(seq), ApproximateArrivalTimestamp: &arrival, Data: []byte(seq)}}
+}
+
+func TestArrivalMergerOrdersAcrossShards(t *testing.T) {
+	base := time.Date(2026, 10, 18, 14, 0, 0, 0, time.UTC)
+	now := base.Add(time.Minute)
+	m := newArrivalMerger([]string{"a", "b"}, time.Hour)
+	var out []string
+	emit := func(r *shardRecord) { out = append(out, r.ShardId+":"+*r.SequenceNumber) }
+
+	m.accept(&mergeBatch{shardId: "a", records: []*shardRecord{
+		arrivalRecord("a", "1", base), arrivalRecord("a", "2", base.Add(2*time.Second)),
+	}, millisBehindLatest: 1000}, now)
+	m.release(now, emit)
+	if len(out) != 0 {
+		t.Fatalf("Records released before shard b reported: %v", out)
+	}
+
+	m.accept(&mergeBatch{shardId: "b", records: []*shardRecord{
+		arrivalRecord("b", "10", base.Add(time.Second)), arrivalRecord("b", "9", base.Add(2*time.Second)),
+	}, millisBehindLatest: 1000}, now)
+	m.release(now, emit)
+	expected := []string{"a:1", "b:10", "a:2", "b:9"}
+	if len(out) != len(expected) {
+		t.Fatalf("Expected %v but got %v", expected, out)
+	}
+	for i := range expected {
+		if out[i] != expected[i] {
+			t.Fatalf("Expected %v but got %v", expected, out)
+		}
+	}
+}
+
+func TestArrivalMergerReleasesAfterMaxSkew(t *testing.T) {
+	base := time.Date(2026, 10, 18, 14, 0, 0, 0, time.UTC)
+	m := newArrivalMerger([]string{"a", "b"}, 5*time.Second)
+	var out []*shardRecord
+	emit := func(r *shardRecord) { out = append(out, r) }
+	m.accept(&mergeBatch{shardId: "a", records: []*shardRecord{arrivalRecord("a", "1", base)}, millisBehindLatest: 1000}, base)
+	m.release(base.Add(time.Second), emit)
+	if len(out) != 0 {
+		t.Fatal("Record released before max skew elapsed")
+	}
+	m.release(base.Add(5*time.Second), emit)
+	if len(out) != 1 {
+		t.Fatal("Record held past max skew")
+	}
+}
+
+func TestLessSequenceNumber(t *testing.T) {
+	if !lessSequenceNumber("9", "10") || lessSequenceNumber("10", "9") || lessSequenceNumber("5", "5") {
+		t.Error("Sequence numbers must compare numerically")
+	}
+}
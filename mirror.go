@@ -0,0 +1,131 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"log"
+	"text/template"
+	"time"
+)
+
+// mirrorSink re-puts records into another stream with their original
+// partition keys. Records are batched per shard and each shard's batches are
+// put one after the other, so records sharing a key keep their order. A
+// record is acked, letting the source checkpoint advance, only once the
+// destination has accepted it.
+type mirrorSink struct {
+	svc        *kinesis.Kinesis
+	streamName string
+	transform  *template.Template
+	throttle   *throttle
+	fail       func(error)
+	batcher    *shardBatcher
+}
+
+func newMirrorSink(svc *kinesis.Kinesis, streamName string, transform *template.Template, t *throttle, batch int, window time.Duration, fail func(error)) *mirrorSink {
+	s := &mirrorSink{svc: svc, streamName: streamName, transform: transform, throttle: t, fail: fail}
+	queues := newShardQueues(s.put)
+	s.batcher = newShardBatcher(batch, window, queues.enqueue)
+	return s
+}
+
+func (s *mirrorSink) Write(record *shardRecord) error {
+	s.batcher.add(record)
+	return nil
+}
+
+func (s *mirrorSink) put(records []*shardRecord) {
+	entries, err := s.entries(records)
+	if err == nil && len(entries) > 0 {
+		err = putInKeyOrder(s.svc, s.streamName, entries, s.throttle)
+	}
+	if err != nil {
+		s.fail(err)
+		return
+	}
+	for _, record := range records {
+		record.Ack()
+	}
+}
+
+// entries builds the PutRecords entries for records, running each through
+// the transform template if there is one. A record the template turns into
+// nothing is dropped.
+func (s *mirrorSink) entries(records []*shardRecord) ([]*kinesis.PutRecordsRequestEntry, error) {
+	entries := make([]*kinesis.PutRecordsRequestEntry, 0, len(records))
+	for _, record := range records {
+		data := record.Data
+		if s.transform != nil {
+			buf, err := executeTemplate(s.transform, record)
+			if err != nil {
+				return nil, err
+			}
+			if buf.Len() == 0 {
+				continue
+			}
+			data = buf.Bytes()
+		}
+		entries = append(entries, &kinesis.PutRecordsRequestEntry{Data: data, PartitionKey: record.PartitionKey})
+	}
+	return entries, nil
+}
+
+func runMirror(cmd *command, args []string) {
+	fs := cmd.flagSet()
+	opts := Options{ShardId: defaultShardId, MinPoll: 200 * time.Millisecond, MaxPoll: 5 * time.Second}
+	var to, fromProfile, fromRegion, toProfile, toRegion, transform string
+	var batch, recordRate, byteRate int
+	var window time.Duration
+	fs.StringVar(&opts.StreamName, "from", selectedTarget.Stream, "Stream name to mirror records from")
+	fs.StringVar(&to, "to", "", "Stream name to mirror records into")
+	addAWSFlags(fs, &opts)
+	fs.StringVar(&fromProfile, "from-profile", "", "Profile for the source stream, defaults to -p")
+	fs.StringVar(&fromRegion, "from-region", "", "Region of the source stream, defaults to -r")
+	fs.StringVar(&toProfile, "to-profile", "", "Profile for the destination stream, defaults to -p")
+	fs.StringVar(&toRegion, "to-region", "", "Region of the destination stream, defaults to -r")
+	fs.StringVar(&opts.ItrType, "iter", Latest, ItrUsage)
+	fs.StringVar(&opts.Checkpoint, "checkpoint", "", checkpointUsage)
+	fs.StringVar(&opts.Match, "match", "", matchUsage)
+	fs.StringVar(&opts.FilterPartitionKey, "partition-key", "", keyFilterUsage)
+	fs.Var(&opts.Where, "where", whereUsage)
+	fs.StringVar(&transform, "transform", "", "Template producing the data to put for each record, see -format; an empty result drops the record")
+	fs.IntVar(&batch, "batch", MaxPutIdx+1, "Maximum records per shard to put at once")
+	fs.DurationVar(&window, "batch-window", time.Second, "Longest a record waits for its batch to fill")
+	fs.IntVar(&recordRate, "rate", 0, "Maximum records put per second, 0 for no limit")
+	fs.IntVar(&byteRate, "rate-bytes", 0, "Maximum bytes put per second, 0 for no limit")
+	fs.Parse(args)
+	if opts.StreamName == "" || to == "" {
+		log.Fatal("mirror requires -from and -to")
+	}
+	toOpts := opts
+	if fromProfile != "" {
+		opts.Profile = fromProfile
+	}
+	if fromRegion != "" {
+		opts.Region = fromRegion
+	}
+	if toProfile != "" {
+		toOpts.Profile = toProfile
+	}
+	if toRegion != "" {
+		toOpts.Region = toRegion
+	}
+	if opts.StreamName == to && opts.Profile == toOpts.Profile && opts.Region == toOpts.Region {
+		log.Fatal("mirror source and destination must differ")
+	}
+	if opts.ItrType != TrimHorizon && opts.ItrType != Latest {
+		log.Fatal("mirror iter must be TRIM_HORIZON or LATEST")
+	}
+	if batch < 1 || batch > MaxPutIdx+1 || window <= 0 {
+		log.Fatal("mirror batch must be between 1 and 500 and batch-window positive")
+	}
+	var tmpl *template.Template
+	if transform != "" {
+		var err error
+		if tmpl, err = parseFormatTemplate(transform); err != nil {
+			log.Fatal("Invalid transform template: ", err)
+		}
+	}
+	l := NewListener(opts, createService(opts))
+	t := &throttle{recordsPerSecond: recordRate, bytesPerSecond: byteRate}
+	l.listenTo(newMirrorSink(createService(toOpts), to, tmpl, t, batch, window, l.fail))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+)
+
+// newTestService returns a Kinesis client that talks to a test server.
+func newTestService(url string) *kinesis.Kinesis {
+	return kinesis.New(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(url),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	})
+}
+
+func mirrorTestRecord(seq, key, data string, acked *int) *shardRecord {
+	return &shardRecord{
+		ShardId: "shardId-000000000000",
+		Record:  &kinesis.Record{SequenceNumber: aws.String(seq), PartitionKey: aws.String(key), Data: []byte(data)},
+		ack:     func() { *acked++ },
+	}
+}
+
+func TestMirrorSinkTransform(t *testing.T) {
+	tmpl := template.Must(parseFormatTemplate(`{{if ne .PartitionKey "skip"}}{{.PartitionKey}}:{{text .Data}}{{end}}`))
+	s := &mirrorSink{transform: tmpl}
+	acked := 0
+	entries, err := s.entries([]*shardRecord{
+		mirrorTestRecord("1", "a", "one", &acked),
+		mirrorTestRecord("2", "skip", "two", &acked),
+		mirrorTestRecord("3", "b", "three", &acked),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+	if string(entries[0].Data) != "a:one" || *entries[0].PartitionKey != "a" {
+		t.Errorf("Unexpected entry %s %s", *entries[0].PartitionKey, entries[0].Data)
+	}
+	if string(entries[1].Data) != "b:three" || *entries[1].PartitionKey != "b" {
+		t.Errorf("Unexpected entry %s %s", *entries[1].PartitionKey, entries[1].Data)
+	}
+}
+
+func TestMirrorSinkAcksAfterPut(t *testing.T) {
+	var mu sync.Mutex
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var in struct {
+			StreamName string
+			Records    []struct{ PartitionKey string }
+		}
+		if err := json.Unmarshal(body, &in); err != nil {
+			t.Error(err)
+		}
+		if in.StreamName != "dest" {
+			t.Errorf("Put into %s, expected dest", in.StreamName)
+		}
+		mu.Lock()
+		for _, record := range in.Records {
+			keys = append(keys, record.PartitionKey)
+		}
+		mu.Unlock()
+		results := make([]map[string]string, len(in.Records))
+		for i := range results {
+			results[i] = map[string]string{"SequenceNumber": "1", "ShardId": "shardId-000000000000"}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"FailedRecordCount": 0, "Records": results})
+	}))
+	defer server.Close()
+
+	svc := newTestService(server.URL)
+	done := make(chan struct{})
+	var acked int
+	s := newMirrorSink(svc, "dest", nil, nil, 2, time.Hour, func(err error) { t.Error(err) })
+	records := []*shardRecord{
+		mirrorTestRecord("1", "a", "one", &acked),
+		mirrorTestRecord("2", "a", "two", &acked),
+	}
+	records[1].ack = func() { acked++; close(done) }
+	for _, record := range records {
+		s.Write(record)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Records were not acked")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if acked != 2 {
+		t.Errorf("Expected 2 acks, got %d", acked)
+	}
+	// Records sharing a key go in separate calls, in order.
+	if len(keys) != 2 || keys[0] != "a" || keys[1] != "a" {
+		t.Errorf("Unexpected puts %v", keys)
+	}
+}
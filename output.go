@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	OutputLines string = "lines"
+	OutputRaw   string = "raw"
+	OutputJSONL string = "jsonl"
+)
+
+// templateFuncs are the helpers available to -format templates.
+var templateFuncs = template.FuncMap{
+	"text":   func(data []byte) string { return string(data) },
+	"base64": func(data []byte) string { return base64.StdEncoding.EncodeToString(data) },
+	"json":   prettyJSON,
+	"time":   func(layout string, t time.Time) string { return t.Format(layout) },
+	"unix":   func(t time.Time) int64 { return t.Unix() },
+	"csv":    csvField,
+}
+
+// separators maps the -separator choices to the bytes written after each
+// record in raw output mode.
+var separators = map[string][]byte{
+	"none":    nil,
+	"newline": []byte{'\n'},
+	"nul":     []byte{0},
+}
+
+// shardRecord is a record together with the shard it was read from.
+type shardRecord struct {
+	ShardId string
+	*kinesis.Record
+	ack func()
+}
+
+// Ack marks the record as handled so its shard's checkpoint can move past it.
+func (r *shardRecord) Ack() {
+	if r.ack != nil {
+		r.ack()
+	}
+}
+
+// recordFormatter writes a single record read from the stream to w.
+type recordFormatter interface {
+	Format(w io.Writer, record *shardRecord) error
+}
+
+func newFormatter(opts Options) recordFormatter {
+	if opts.Format != "" {
+		return &templateFormatter{tmpl: template.Must(parseFormatTemplate(opts.Format))}
+	}
+	switch opts.Output {
+	case OutputRaw:
+		return &rawFormatter{separator: separators[opts.Separator]}
+	case OutputJSONL:
+		return &jsonlFormatter{}
+	default:
+		return &linesFormatter{}
+	}
+}
+
+// linesFormatter splits each record into lines, trims them and drops blank
+// ones. It is the default and suits text payloads packed by the uploader.
+type linesFormatter struct{}
+
+func (f *linesFormatter) Format(w io.Writer, record *shardRecord) error {
+	brdr := bufio.NewScanner(bytes.NewReader(record.Data))
+	// A line can be as long as the largest record.
+	brdr.Buffer(make([]byte, 0, 64*1024), maxRecordSize)
+	for brdr.Scan() {
+		//These lines are for removing blank lines from data payloads
+		data := bytes.TrimSpace(brdr.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		data = append(data, '\n')
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return brdr.Err()
+}
+
+// rawFormatter writes each record's data untouched followed by separator.
+type rawFormatter struct {
+	separator []byte
+}
+
+func (f *rawFormatter) Format(w io.Writer, record *shardRecord) error {
+	if _, err := w.Write(record.Data); err != nil {
+		return err
+	}
+	if len(f.separator) > 0 {
+		_, err := w.Write(f.separator)
+		return err
+	}
+	return nil
+}
+
+// jsonRecord is the JSON Lines representation of a record. Data holds the
+// payload as text when it is valid UTF-8 and base64 otherwise, as indicated
+// by DataEncoding.
+type jsonRecord struct {
+	ShardId                     string     `json:"shardId"`
+	SequenceNumber              string     `json:"sequenceNumber"`
+	PartitionKey                string     `json:"partitionKey"`
+	ApproximateArrivalTimestamp *time.Time `json:"approximateArrivalTimestamp,omitempty"`
+	Data                        string     `json:"data"`
+	DataEncoding                string     `json:"dataEncoding"`
+}
+
+// jsonlFormatter writes one JSON object per record with its metadata.
+type jsonlFormatter struct{}
+
+func (f *jsonlFormatter) Format(w io.Writer, record *shardRecord) error {
+	jr := jsonRecord{
+		ShardId:                     record.ShardId,
+		SequenceNumber:              aws.StringValue(record.SequenceNumber),
+		PartitionKey:                aws.StringValue(record.PartitionKey),
+		ApproximateArrivalTimestamp: record.ApproximateArrivalTimestamp,
+	}
+	if utf8.Valid(record.Data) {
+		jr.Data, jr.DataEncoding = string(record.Data), "text"
+	} else {
+		jr.Data, jr.DataEncoding = base64.StdEncoding.EncodeToString(record.Data), "base64"
+	}
+	return json.NewEncoder(w).Encode(&jr)
+}
+
+// templateRecord is the value -format templates are executed against. It
+// flattens the kinesis record so fields can be passed straight to helpers.
+type templateRecord struct {
+	ShardId                     string
+	SequenceNumber              string
+	PartitionKey                string
+	ApproximateArrivalTimestamp time.Time
+	Data                        []byte
+}
+
+func parseFormatTemplate(text string) (*template.Template, error) {
+	return template.New("format").Funcs(templateFuncs).Parse(text)
+}
+
+// templateFormatter executes a user supplied template for each record,
+// writing a newline after each one.
+type templateFormatter struct {
+	tmpl *template.Template
+}
+
+func (f *templateFormatter) Format(w io.Writer, record *shardRecord) error {
+	buf, err := executeTemplate(f.tmpl, record)
+	if err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// executeTemplate executes tmpl against record.
+func executeTemplate(tmpl *template.Template, record *shardRecord) (*bytes.Buffer, error) {
+	tr := templateRecord{
+		ShardId:        record.ShardId,
+		SequenceNumber: aws.StringValue(record.SequenceNumber),
+		PartitionKey:   aws.StringValue(record.PartitionKey),
+		Data:           record.Data,
+	}
+	if record.ApproximateArrivalTimestamp != nil {
+		tr.ApproximateArrivalTimestamp = *record.ApproximateArrivalTimestamp
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, &tr); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+// prettyJSON indents v as JSON. Byte slices and strings are taken to already
+// hold JSON, so {{json .Data}} pretty prints a JSON payload.
+func prettyJSON(v interface{}) (string, error) {
+	var raw []byte
+	switch t := v.(type) {
+	case []byte:
+		raw = t
+	case string:
+		raw = []byte(t)
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		raw = b
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, raw, "", "  "); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// csvField quotes v for use as a CSV field when it contains a comma, quote
+// or line break.
+func csvField(v interface{}) string {
+	var field string
+	switch t := v.(type) {
+	case []byte:
+		field = string(t)
+	case string:
+		field = t
+	default:
+		b, _ := json.Marshal(v)
+		field = string(b)
+	}
+	if !strings.ContainsAny(field, ",\"\r\n") {
+		return field
+	}
+	return `"` + strings.Replace(field, `"`, `""`, -1) + `"`
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"testing"
+	"time"
+)
+
+func TestRawFormatterIsByteExact(t *testing.T) {
+	data := []byte{0, ' ', '\n', 0xff, '\n', '\n', ' '}
+	data = append(data, bytes.Repeat([]byte{'x'}, 100000)...)
+	for name, sep := range separators {
+		var buf bytes.Buffer
+		f := &rawFormatter{separator: sep}
+		if err := f.Format(&buf, &shardRecord{Record: &kinesis.Record{Data: data}}); err != nil {
+			t.Fatal(err)
+		}
+		expected := append(append([]byte{}, data...), sep...)
+		if !bytes.Equal(buf.Bytes(), expected) {
+			t.Errorf("separator %s: raw output does not match record data", name)
+		}
+	}
+}
+
+func TestLinesFormatterDropsBlankLines(t *testing.T) {
+	var buf bytes.Buffer
+	f := &linesFormatter{}
+	if err := f.Format(&buf, &shardRecord{Record: &kinesis.Record{Data: []byte(" a \n\n b\n")}}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "a\nb\n" {
+		t.Errorf("Expected blank lines to be dropped but got %q", buf.String())
+	}
+}
+
+func TestLinesFormatterLongLine(t *testing.T) {
+	line := bytes.Repeat([]byte{'x'}, maxRecordSize-1)
+	var buf bytes.Buffer
+	f := &linesFormatter{}
+	if err := f.Format(&buf, &shardRecord{Record: &kinesis.Record{Data: line}}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), append(line, '\n')) {
+		t.Errorf("Expected the %d byte line to be written whole, got %d bytes", len(line), buf.Len())
+	}
+}
+
+func TestJSONLFormatterEncodesData(t *testing.T) {
+	cases := map[string]struct {
+		data             []byte
+		expected, encode string
+	}{
+		"text":   {[]byte("héllo"), "héllo", "text"},
+		"binary": {[]byte{0xff, 0xfe}, "//4=", "base64"},
+	}
+	for name, c := range cases {
+		var buf bytes.Buffer
+		rec := &shardRecord{ShardId: "shardId-000000000000", Record: &kinesis.Record{
+			Data: c.data, PartitionKey: aws.String("pk"), SequenceNumber: aws.String("49"),
+		}}
+		if err := (&jsonlFormatter{}).Format(&buf, rec); err != nil {
+			t.Fatal(err)
+		}
+		var jr jsonRecord
+		if err := json.Unmarshal(buf.Bytes(), &jr); err != nil {
+			t.Fatal(err)
+		}
+		if jr.Data != c.expected || jr.DataEncoding != c.encode {
+			t.Errorf("%s: Expected %q (%s) but got %q (%s)", name, c.expected, c.encode, jr.Data, jr.DataEncoding)
+		}
+		if jr.ShardId != rec.ShardId || jr.PartitionKey != "pk" || jr.SequenceNumber != "49" {
+			t.Errorf("%s: metadata not carried over: %+v", name, jr)
+		}
+	}
+}
+
+func TestTemplateFormatter(t *testing.T) {
+	tmpl, err := parseFormatTemplate(`{{.ShardId}} {{.SequenceNumber}} {{.Data | printf "%s"}} {{.ApproximateArrivalTimestamp | time "15:04"}} {{csv .PartitionKey}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	arrival := time.Date(2026, 10, 18, 14, 5, 0, 0, time.UTC)
+	rec := &shardRecord{ShardId: "shardId-000000000001", Record: &kinesis.Record{
+		Data: []byte("hello"), PartitionKey: aws.String(`a,"b"`), SequenceNumber: aws.String("49"),
+		ApproximateArrivalTimestamp: &arrival,
+	}}
+	var buf bytes.Buffer
+	if err := (&templateFormatter{tmpl: tmpl}).Format(&buf, rec); err != nil {
+		t.Fatal(err)
+	}
+	expected := "shardId-000000000001 49 hello 14:05 \"a,\"\"b\"\"\"\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %q but got %q", expected, buf.String())
+	}
+}
+
+func TestPrettyJSON(t *testing.T) {
+	out, err := prettyJSON([]byte(`{"level":"ERROR"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "{\n  \"level\": \"ERROR\"\n}" {
+		t.Errorf("Unexpected pretty JSON %q", out)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"sync"
+	"time"
+)
+
+const (
+	// Kinesis allows five GetRecords calls and 2 MB of reads per second per shard.
+	maxShardCallsPerSecond = 5
+	maxShardBytesPerSecond = 2 * 1024 * 1024
+)
+
+// pollInterval adapts how long a shard reader waits between GetRecords
+// calls: not at all while it is behind, min while records keep arriving, and
+// doubling up to max while the shard stays empty.
+type pollInterval struct {
+	min, max, current time.Duration
+}
+
+func newPollInterval(min, max time.Duration) *pollInterval {
+	return &pollInterval{min: min, max: max, current: min}
+}
+
+func (p *pollInterval) next(records int, millisBehindLatest int64) time.Duration {
+	if millisBehindLatest > 0 {
+		p.current = p.min
+		return 0
+	}
+	if records > 0 {
+		p.current = p.min
+		return p.min
+	}
+	wait := p.current
+	p.current *= 2
+	if p.current > p.max {
+		p.current = p.max
+	}
+	return wait
+}
+
+// backoff is used after the shard's throughput was exceeded.
+func (p *pollInterval) backoff() time.Duration {
+	p.current = p.max
+	return p.max
+}
+
+// shardLimiter keeps every reader of a shard in this process within the
+// shard's read quotas. Calls are spaced evenly and the bytes each call
+// returned push the next call out far enough to stay under the byte rate.
+type shardLimiter struct {
+	mu   sync.Mutex
+	next time.Time
+}
+
+var shardLimiters = struct {
+	sync.Mutex
+	m map[string]*shardLimiter
+}{m: make(map[string]*shardLimiter)}
+
+// limiterFor returns the limiter shared by all readers of streamName's shardId.
+func limiterFor(streamName, shardId string) *shardLimiter {
+	shardLimiters.Lock()
+	defer shardLimiters.Unlock()
+	key := streamName + "/" + shardId
+	limiter, ok := shardLimiters.m[key]
+	if !ok {
+		limiter = &shardLimiter{}
+		shardLimiters.m[key] = limiter
+	}
+	return limiter
+}
+
+// reserve returns how long the caller must wait before its GetRecords call.
+func (sl *shardLimiter) reserve(now time.Time) time.Duration {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	at := sl.next
+	if at.Before(now) {
+		at = now
+	}
+	sl.next = at.Add(time.Second / maxShardCallsPerSecond)
+	return at.Sub(now)
+}
+
+func (sl *shardLimiter) wait() {
+	time.Sleep(sl.reserve(time.Now()))
+}
+
+// consumed accounts for n bytes read from the shard at now.
+func (sl *shardLimiter) consumed(n int, now time.Time) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	at := now.Add(time.Duration(n) * time.Second / maxShardBytesPerSecond)
+	if at.After(sl.next) {
+		sl.next = at
+	}
+}
+
+func isThroughputExceeded(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == "ProvisionedThroughputExceededException"
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPollIntervalAdapts(t *testing.T) {
+	p := newPollInterval(100*time.Millisecond, 400*time.Millisecond)
+	if wait := p.next(10, 5000); wait != 0 {
+		t.Errorf("Expected immediate re-poll while behind but got %s", wait)
+	}
+	expected := []time.Duration{100, 200, 400, 400}
+	for _, e := range expected {
+		if wait := p.next(0, 0); wait != e*time.Millisecond {
+			t.Errorf("Expected backoff of %s but got %s", e*time.Millisecond, wait)
+		}
+	}
+	if wait := p.next(3, 0); wait != 100*time.Millisecond {
+		t.Errorf("Expected reset to min interval but got %s", wait)
+	}
+}
+
+func TestShardLimiterSpacesCalls(t *testing.T) {
+	sl := &shardLimiter{}
+	now := time.Date(2026, 10, 18, 14, 0, 0, 0, time.UTC)
+	for i := 0; i < maxShardCallsPerSecond; i++ {
+		if wait := sl.reserve(now); wait != time.Duration(i)*time.Second/maxShardCallsPerSecond {
+			t.Fatalf("Call %d: unexpected wait %s", i, wait)
+		}
+	}
+}
+
+func TestShardLimiterAccountsBytes(t *testing.T) {
+	sl := &shardLimiter{}
+	now := time.Date(2026, 10, 18, 14, 0, 0, 0, time.UTC)
+	sl.reserve(now)
+	sl.consumed(2*maxShardBytesPerSecond, now)
+	if wait := sl.reserve(now); wait != 2*time.Second {
+		t.Errorf("Expected reading 4 MB to delay the next call by 2s but got %s", wait)
+	}
+}
+
+func TestLimiterForIsShared(t *testing.T) {
+	if limiterFor("s", "shardId-0") != limiterFor("s", "shardId-0") {
+		t.Error("Readers of the same shard must share a limiter")
+	}
+	if limiterFor("s", "shardId-0") == limiterFor("s", "shardId-1") {
+		t.Error("Different shards must not share a limiter")
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	maxPutRecordsBytes = 5 * 1024 * 1024
+	maxPutAttempts     = 10
+)
+
+// throttle spaces out work so that no more than recordsPerSecond records and
+// bytesPerSecond bytes go through per second. Zero disables a limit. A
+// throttle may be shared by goroutines, which then take turns.
+type throttle struct {
+	recordsPerSecond, bytesPerSecond int
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+func (t *throttle) wait(records, bytes int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	time.Sleep(t.next.Sub(now))
+	var d time.Duration
+	if t.recordsPerSecond > 0 {
+		d = time.Duration(records) * time.Second / time.Duration(t.recordsPerSecond)
+	}
+	if t.bytesPerSecond > 0 {
+		if b := time.Duration(bytes) * time.Second / time.Duration(t.bytesPerSecond); b > d {
+			d = b
+		}
+	}
+	t.next = t.next.Add(d)
+}
+
+// keyOrderedBatches splits entries into PutRecords batches that never hold
+// two records with the same partition key. Sending the batches one after the
+// other, each only once all of its records succeeded, keeps the order of the
+// records sharing a key.
+func keyOrderedBatches(entries []*kinesis.PutRecordsRequestEntry) [][]*kinesis.PutRecordsRequestEntry {
+	var batches [][]*kinesis.PutRecordsRequestEntry
+	var batch []*kinesis.PutRecordsRequestEntry
+	keys := make(map[string]bool)
+	size := 0
+	for _, entry := range entries {
+		key := aws.StringValue(entry.PartitionKey)
+		entrySize := len(entry.Data) + len(key)
+		if len(batch) > MaxPutIdx || keys[key] || size+entrySize > maxPutRecordsBytes {
+			batches = append(batches, batch)
+			batch, keys, size = nil, make(map[string]bool), 0
+		}
+		batch = append(batch, entry)
+		keys[key] = true
+		size += entrySize
+	}
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+// putInKeyOrder puts entries into streamName, preserving the order of
+// records that share a partition key. Failed records are retried with
+// backoff before the next batch is sent.
+func putInKeyOrder(svc *kinesis.Kinesis, streamName string, entries []*kinesis.PutRecordsRequestEntry, t *throttle) error {
+	for _, batch := range keyOrderedBatches(entries) {
+		size := 0
+		for _, entry := range batch {
+			size += len(entry.Data)
+		}
+		t.wait(len(batch), size)
+		backoff := 100 * time.Millisecond
+		for attempt := 1; len(batch) > 0; attempt++ {
+			out, err := svc.PutRecords(&kinesis.PutRecordsInput{Records: batch, StreamName: &streamName})
+			if err != nil {
+				return err
+			}
+			if *out.FailedRecordCount == 0 {
+				break
+			}
+			if attempt == maxPutAttempts {
+				return fmt.Errorf("%d records still failing after %d attempts", *out.FailedRecordCount, attempt)
+			}
+			var failed []*kinesis.PutRecordsRequestEntry
+			for i, result := range out.Records {
+				if result.ErrorCode != nil {
+					failed = append(failed, batch[i])
+				}
+			}
+			log.Printf("%d records failed to put, retrying in %s", len(failed), backoff)
+			time.Sleep(backoff)
+			backoff *= 2
+			batch = failed
+		}
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"sort"
+	"time"
+)
+
+// hashRange is an inclusive range of hash keys owned by one open shard.
+type hashRange struct {
+	start, end *big.Int
+}
+
+func (r hashRange) String() string {
+	return fmt.Sprintf("%s-%s", hashKeyPercent(r.start), hashKeyPercent(new(big.Int).Add(r.end, big.NewInt(1))))
+}
+
+type byStart []hashRange
+
+func (r byStart) Len() int           { return len(r) }
+func (r byStart) Less(i, j int) bool { return r[i].start.Cmp(r[j].start) < 0 }
+func (r byStart) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
+func hashKeyPercent(key *big.Int) string {
+	share, _ := new(big.Rat).SetFrac(key, hashKeySpace).Float64()
+	return fmt.Sprintf("%.2f%%", share*100)
+}
+
+// reshardStep is a single SplitShard or MergeShards call. A split divides
+// the shard owning r at at; a merge joins the two shards owning r, the second
+// of which starts at at.
+type reshardStep struct {
+	split bool
+	r     hashRange
+	at    *big.Int
+}
+
+func (s reshardStep) String() string {
+	if s.split {
+		return fmt.Sprintf("split %s at %s (hash key %s)", s.r, hashKeyPercent(s.at), s.at)
+	}
+	return fmt.Sprintf("merge %s and %s", hashRange{s.r.start, new(big.Int).Sub(s.at, big.NewInt(1))}, hashRange{s.at, s.r.end})
+}
+
+// uniformRanges divides the hash key space into n ranges of nearly equal size.
+func uniformRanges(n int) []hashRange {
+	ranges := make([]hashRange, n)
+	bound := func(i int) *big.Int {
+		b := new(big.Int).Mul(hashKeySpace, big.NewInt(int64(i)))
+		return b.Div(b, big.NewInt(int64(n)))
+	}
+	for i := range ranges {
+		ranges[i] = hashRange{bound(i), new(big.Int).Sub(bound(i+1), big.NewInt(1))}
+	}
+	return ranges
+}
+
+func openHashRanges(shards []*kinesis.Shard) ([]hashRange, error) {
+	var ranges []hashRange
+	for _, shard := range shards {
+		if shard.SequenceNumberRange != nil && shard.SequenceNumberRange.EndingSequenceNumber != nil {
+			continue
+		}
+		start, ok1 := new(big.Int).SetString(aws.StringValue(shard.HashKeyRange.StartingHashKey), 10)
+		end, ok2 := new(big.Int).SetString(aws.StringValue(shard.HashKeyRange.EndingHashKey), 10)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("shard %s has an invalid hash key range", aws.StringValue(shard.ShardId))
+		}
+		ranges = append(ranges, hashRange{start, end})
+	}
+	sort.Sort(byStart(ranges))
+	return ranges, nil
+}
+
+// planReshard works out the steps turning the open shards' ranges into n
+// uniform ones: first every shard is split at each target boundary inside
+// it, then the pieces making up each target range are merged one by one.
+func planReshard(current []hashRange, n int) []reshardStep {
+	targets := uniformRanges(n)
+	var steps []reshardStep
+	var pieces []hashRange
+	for _, r := range current {
+		for _, t := range targets[1:] {
+			if r.start.Cmp(t.start) < 0 && t.start.Cmp(r.end) <= 0 {
+				steps = append(steps, reshardStep{split: true, r: r, at: t.start})
+				pieces = append(pieces, hashRange{r.start, new(big.Int).Sub(t.start, big.NewInt(1))})
+				r = hashRange{t.start, r.end}
+			}
+		}
+		pieces = append(pieces, r)
+	}
+	for _, t := range targets {
+		var merged *hashRange
+		for _, p := range pieces {
+			if p.start.Cmp(t.start) < 0 || p.end.Cmp(t.end) > 0 {
+				continue
+			}
+			if merged == nil {
+				merged = &hashRange{p.start, p.end}
+				continue
+			}
+			steps = append(steps, reshardStep{r: hashRange{merged.start, p.end}, at: p.start})
+			merged.end = p.end
+		}
+	}
+	return steps
+}
+
+// openShardWithRange finds the open shard owning exactly the hash keys from
+// start to end.
+func openShardWithRange(shards []*kinesis.Shard, start, end *big.Int) *kinesis.Shard {
+	for _, shard := range shards {
+		if shard.SequenceNumberRange != nil && shard.SequenceNumberRange.EndingSequenceNumber != nil {
+			continue
+		}
+		if aws.StringValue(shard.HashKeyRange.StartingHashKey) == start.String() && aws.StringValue(shard.HashKeyRange.EndingHashKey) == end.String() {
+			return shard
+		}
+	}
+	return nil
+}
+
+// executeStep makes the call for step against the current open shards.
+func executeStep(svc *kinesis.Kinesis, streamName string, step reshardStep) error {
+	shards := getShardIds(svc, streamName)
+	if step.split {
+		shard := openShardWithRange(shards, step.r.start, step.r.end)
+		if shard == nil {
+			return fmt.Errorf("no open shard owns %s", step.r)
+		}
+		_, err := svc.SplitShard(&kinesis.SplitShardInput{StreamName: &streamName, ShardToSplit: shard.ShardId, NewStartingHashKey: aws.String(step.at.String())})
+		return err
+	}
+	left := openShardWithRange(shards, step.r.start, new(big.Int).Sub(step.at, big.NewInt(1)))
+	right := openShardWithRange(shards, step.at, step.r.end)
+	if left == nil || right == nil {
+		return fmt.Errorf("no open shards to %s", step)
+	}
+	_, err := svc.MergeShards(&kinesis.MergeShardsInput{StreamName: &streamName, ShardToMerge: left.ShardId, AdjacentShardToMerge: right.ShardId})
+	return err
+}
+
+func printPlan(w io.Writer, streamName string, current []hashRange, n int, steps []reshardStep) {
+	fmt.Fprintf(w, "Resharding %s from %d to %d open shards in %d steps\n", streamName, len(current), n, len(steps))
+	for i, step := range steps {
+		fmt.Fprintf(w, "%3d. %s\n", i+1, step)
+	}
+}
+
+func runStreamsReshard(cmd *command, args []string) {
+	fs := cmd.flagSet()
+	var opts Options
+	var target int
+	var dryRun bool
+	var timeout time.Duration
+	addStreamFlags(fs, &opts)
+	fs.IntVar(&target, "target", 0, "Number of evenly sized open shards to end up with")
+	fs.BoolVar(&dryRun, "dry-run", false, "Only print the plan")
+	fs.DurationVar(&timeout, "timeout", 10*time.Minute, "Longest to wait for the stream to become ACTIVE after each step")
+	fs.Parse(args)
+	requireStreamName(opts)
+	if target < 1 {
+		log.Fatal("reshard requires a positive -target")
+	}
+	svc := createService(opts)
+	current, err := openHashRanges(getShardIds(svc, opts.StreamName))
+	if err != nil {
+		log.Fatal(err)
+	}
+	steps := planReshard(current, target)
+	printPlan(os.Stdout, opts.StreamName, current, target, steps)
+	if dryRun || len(steps) == 0 {
+		return
+	}
+	if err := waitForStream(svc, opts.StreamName, kinesis.StreamStatusActive, timeout); err != nil {
+		log.Fatal(err)
+	}
+	for i, step := range steps {
+		log.Printf("Step %d of %d: %s", i+1, len(steps), step)
+		if err := executeStep(svc, opts.StreamName, step); err != nil {
+			log.Fatalf("Step %d failed: %s", i+1, err)
+		}
+		if err := waitForStream(svc, opts.StreamName, kinesis.StreamStatusActive, timeout); err != nil {
+			log.Fatal(err)
+		}
+	}
+	log.Printf("Stream %s has %d evenly sized open shards", opts.StreamName, target)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+)
+
+// applyReshard carries out steps on ranges the way Kinesis would, failing
+// the test on a step that names shards which do not exist.
+func applyReshard(t *testing.T, ranges []hashRange, steps []reshardStep) []hashRange {
+	find := func(start, end *big.Int) int {
+		for i, r := range ranges {
+			if r.start.Cmp(start) == 0 && r.end.Cmp(end) == 0 {
+				return i
+			}
+		}
+		t.Fatalf("No shard owns %s-%s", start, end)
+		return -1
+	}
+	for _, step := range steps {
+		before := new(big.Int).Sub(step.at, big.NewInt(1))
+		if step.split {
+			i := find(step.r.start, step.r.end)
+			ranges = append(ranges[:i], append([]hashRange{{step.r.start, before}, {step.at, step.r.end}}, ranges[i+1:]...)...)
+			continue
+		}
+		i := find(step.r.start, before)
+		if j := find(step.at, step.r.end); j != i+1 {
+			t.Fatalf("Merging shards that are not adjacent: %s", step)
+		}
+		ranges = append(ranges[:i], append([]hashRange{step.r}, ranges[i+2:]...)...)
+	}
+	return ranges
+}
+
+func TestPlanReshard(t *testing.T) {
+	tests := []struct {
+		from, to, steps int
+	}{
+		{1, 1, 0},
+		{1, 4, 3},
+		{4, 2, 2},
+		{2, 3, 3},
+		{3, 2, 3},
+		{4, 4, 0},
+		{5, 7, 10},
+	}
+	for _, test := range tests {
+		steps := planReshard(uniformRanges(test.from), test.to)
+		if len(steps) != test.steps {
+			t.Errorf("%d to %d: expected %d steps, got %d: %v", test.from, test.to, test.steps, len(steps), steps)
+		}
+		got := applyReshard(t, uniformRanges(test.from), steps)
+		want := uniformRanges(test.to)
+		if len(got) != len(want) {
+			t.Fatalf("%d to %d: ended with %d shards", test.from, test.to, len(got))
+		}
+		for i := range want {
+			if got[i].start.Cmp(want[i].start) != 0 || got[i].end.Cmp(want[i].end) != 0 {
+				t.Errorf("%d to %d: shard %d owns %s, expected %s", test.from, test.to, i, got[i], want[i])
+			}
+		}
+	}
+}
+
+func TestUniformRangesCoverHashKeySpace(t *testing.T) {
+	ranges := uniformRanges(3)
+	if ranges[0].start.Sign() != 0 || ranges[2].end.String() != maxHashKey {
+		t.Errorf("Ranges do not cover the hash key space: %s %s", ranges[0].start, ranges[2].end)
+	}
+	for i := 1; i < len(ranges); i++ {
+		if new(big.Int).Add(ranges[i-1].end, big.NewInt(1)).Cmp(ranges[i].start) != 0 {
+			t.Errorf("Gap between ranges %d and %d", i-1, i)
+		}
+	}
+}
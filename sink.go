@@ -0,0 +1,26 @@
+package main
+
+import (
+	"io"
+)
+
+// recordSink is where the Listener delivers records. Write is only called
+// from the Listener's output goroutine. A sink acks each record once it has
+// been handled, which lets the record's checkpoint advance.
+type recordSink interface {
+	Write(record *shardRecord) error
+}
+
+// writerSink formats records onto an io.Writer such as stdout.
+type writerSink struct {
+	w         io.Writer
+	formatter recordFormatter
+}
+
+func (s *writerSink) Write(record *shardRecord) error {
+	if err := s.formatter.Format(s.w, record); err != nil {
+		return err
+	}
+	record.Ack()
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+type shardStats struct {
+	lastSequenceNumber string
+	records, bytes     int64
+	matched            int64
+	millisBehindLatest int64
+	// counts at the previous report, used to compute rates
+	reportedRecords, reportedBytes int64
+}
+
+// listenStats tracks per-shard progress of a Listener for lag reporting.
+type listenStats struct {
+	mu           sync.Mutex
+	started      time.Time
+	lastReported time.Time
+	shards       map[string]*shardStats
+}
+
+func newListenStats(now time.Time) *listenStats {
+	return &listenStats{started: now, lastReported: now, shards: make(map[string]*shardStats)}
+}
+
+// update records a GetRecords call that returned records, of which matched
+// passed the listener's filters.
+func (s *listenStats) update(shardId string, records []*kinesis.Record, matched int, millisBehindLatest int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ss, ok := s.shards[shardId]
+	if !ok {
+		ss = &shardStats{}
+		s.shards[shardId] = ss
+	}
+	for _, record := range records {
+		ss.records++
+		ss.bytes += int64(len(record.Data))
+	}
+	ss.matched += int64(matched)
+	if len(records) > 0 {
+		ss.lastSequenceNumber = *records[len(records)-1].SequenceNumber
+	}
+	ss.millisBehindLatest = millisBehindLatest
+}
+
+func (s *listenStats) shardIds() []string {
+	ids := make([]string, 0, len(s.shards))
+	for id := range s.shards {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// report writes a table of every shard's rates since the previous report.
+func (s *listenStats) report(w io.Writer, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elapsed := now.Sub(s.lastReported).Seconds()
+	s.lastReported = now
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SHARD\tLAST SEQUENCE NUMBER\tRECORDS/S\tMB/S\tMATCHED\tMS BEHIND")
+	for _, id := range s.shardIds() {
+		ss := s.shards[id]
+		var recordRate, byteRate float64
+		if elapsed > 0 {
+			recordRate = float64(ss.records-ss.reportedRecords) / elapsed
+			byteRate = float64(ss.bytes-ss.reportedBytes) / elapsed
+		}
+		ss.reportedRecords, ss.reportedBytes = ss.records, ss.bytes
+		fmt.Fprintf(tw, "%s\t%s\t%.1f\t%.3f\t%d\t%d\n", id, ss.lastSequenceNumber, recordRate, byteRate/1e6, ss.matched, ss.millisBehindLatest)
+	}
+	tw.Flush()
+}
+
+// summary writes the totals for the whole run and the shard furthest behind.
+func (s *listenStats) summary(w io.Writer, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var records, bytes, matched, maxBehind int64
+	var maxShard string
+	for _, id := range s.shardIds() {
+		ss := s.shards[id]
+		records += ss.records
+		bytes += ss.bytes
+		matched += ss.matched
+		if maxShard == "" || ss.millisBehindLatest > maxBehind {
+			maxShard, maxBehind = id, ss.millisBehindLatest
+		}
+	}
+	elapsed := now.Sub(s.started)
+	fmt.Fprintf(w, "Read %d records (%.3f MB, %d matched) from %d shards in %s", records, float64(bytes)/1e6, matched, len(s.shards), elapsed)
+	if secs := elapsed.Seconds(); secs > 0 {
+		fmt.Fprintf(w, ", %.1f records/s", float64(records)/secs)
+	}
+	fmt.Fprintln(w)
+	if maxShard != "" {
+		fmt.Fprintf(w, "Furthest behind: %s at %d ms\n", maxShard, maxBehind)
+	}
+}
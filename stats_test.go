@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListenStatsReport(t *testing.T) {
+	start := time.Date(2026, 10, 18, 14, 0, 0, 0, time.UTC)
+	s := newListenStats(start)
+	s.update("shardId-000000000000", []*kinesis.Record{
+		{SequenceNumber: aws.String("1"), Data: make([]byte, 500000)},
+		{SequenceNumber: aws.String("2"), Data: make([]byte, 500000)},
+	}, 1, 1500)
+	s.update("shardId-000000000001", nil, 0, 0)
+
+	var buf bytes.Buffer
+	s.report(&buf, start.Add(2*time.Second))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected a header and two shard rows but got %q", buf.String())
+	}
+	if fields := strings.Fields(lines[1]); strings.Join(fields, " ") != "shardId-000000000000 2 1.0 0.500 1 1500" {
+		t.Errorf("Unexpected row %q", lines[1])
+	}
+
+	buf.Reset()
+	s.report(&buf, start.Add(4*time.Second))
+	if fields := strings.Fields(strings.Split(buf.String(), "\n")[1]); fields[2] != "0.0" {
+		t.Errorf("Rates should only cover records since the previous report: %q", buf.String())
+	}
+
+	buf.Reset()
+	s.summary(&buf, start.Add(4*time.Second))
+	if !strings.Contains(buf.String(), "Read 2 records (1.000 MB, 1 matched)") || !strings.Contains(buf.String(), "shardId-000000000000 at 1500 ms") {
+		t.Errorf("Unexpected summary %q", buf.String())
+	}
+}
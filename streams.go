@@ -0,0 +1,525 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	tableOutput = "table"
+	jsonOutput  = "json"
+)
+
+// streamInfo is what c2k streams describe reports about a stream.
+type streamInfo struct {
+	StreamName           string            `json:"streamName"`
+	StreamARN            string            `json:"streamARN"`
+	StreamStatus         string            `json:"streamStatus"`
+	RetentionPeriodHours int64             `json:"retentionPeriodHours"`
+	Tags                 map[string]string `json:"tags"`
+	Shards               []*shardInfo      `json:"shards"`
+}
+
+type shardInfo struct {
+	ShardId                string   `json:"shardId"`
+	Open                   bool     `json:"open"`
+	ParentShardId          string   `json:"parentShardId,omitempty"`
+	AdjacentParentShardId  string   `json:"adjacentParentShardId,omitempty"`
+	ChildShardIds          []string `json:"childShardIds,omitempty"`
+	StartingHashKey        string   `json:"startingHashKey"`
+	EndingHashKey          string   `json:"endingHashKey"`
+	HashKeyShare           float64  `json:"hashKeyShare"`
+	StartingSequenceNumber string   `json:"startingSequenceNumber"`
+	EndingSequenceNumber   string   `json:"endingSequenceNumber,omitempty"`
+}
+
+func addOutputFlag(fs *flag.FlagSet, output *string) {
+	fs.StringVar(output, "output", tableOutput, "Output format. Valid choices: table, json")
+}
+
+func checkOutput(output string) {
+	if output != tableOutput && output != jsonOutput {
+		log.Fatal("Invalid output given ", output)
+	}
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+func runStreamsList(cmd *command, args []string) {
+	fs := cmd.flagSet()
+	var opts Options
+	var output string
+	addAWSFlags(fs, &opts)
+	addOutputFlag(fs, &output)
+	fs.Parse(args)
+	checkOutput(output)
+	svc := createService(opts)
+	names := []string{}
+	err := svc.ListStreamsPages(&kinesis.ListStreamsInput{}, func(out *kinesis.ListStreamsOutput, lastPage bool) bool {
+		names = append(names, aws.StringValueSlice(out.StreamNames)...)
+		return true
+	})
+	if err != nil {
+		log.Fatal("Could not list streams: ", err)
+	}
+	if output == jsonOutput {
+		writeJSON(os.Stdout, names)
+		return
+	}
+	for _, name := range names {
+		fmt.Println(name)
+	}
+}
+
+func runStreamsDescribe(cmd *command, args []string) {
+	fs := cmd.flagSet()
+	var opts Options
+	var output string
+	addStreamFlags(fs, &opts)
+	addOutputFlag(fs, &output)
+	fs.Parse(args)
+	requireStreamName(opts)
+	checkOutput(output)
+	info, err := describeStream(createService(opts), opts.StreamName)
+	if err != nil {
+		log.Fatal("Could not describe stream: ", err)
+	}
+	if output == jsonOutput {
+		writeJSON(os.Stdout, info)
+		return
+	}
+	info.writeTable(os.Stdout)
+}
+
+// describeStream gathers the description, every shard and the tags of a
+// stream.
+func describeStream(svc *kinesis.Kinesis, streamName string) (*streamInfo, error) {
+	info := &streamInfo{StreamName: streamName}
+	var shards []*kinesis.Shard
+	err := svc.DescribeStreamPages(&kinesis.DescribeStreamInput{StreamName: &streamName}, func(out *kinesis.DescribeStreamOutput, lastPage bool) bool {
+		// The SDK calls back with an empty page when the request fails.
+		desc := out.StreamDescription
+		if desc == nil {
+			return false
+		}
+		info.StreamARN = aws.StringValue(desc.StreamARN)
+		info.StreamStatus = aws.StringValue(desc.StreamStatus)
+		info.RetentionPeriodHours = aws.Int64Value(desc.RetentionPeriodHours)
+		shards = append(shards, desc.Shards...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	info.Shards = shardInfos(shards)
+	if info.Tags, err = streamTags(svc, streamName); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// shardInfos describes shards, working out each shard's children from the
+// parents of the others.
+func shardInfos(shards []*kinesis.Shard) []*shardInfo {
+	infos := make([]*shardInfo, len(shards))
+	byId := make(map[string]*shardInfo)
+	for i, shard := range shards {
+		info := &shardInfo{
+			ShardId:               aws.StringValue(shard.ShardId),
+			ParentShardId:         aws.StringValue(shard.ParentShardId),
+			AdjacentParentShardId: aws.StringValue(shard.AdjacentParentShardId),
+			HashKeyShare:          hashRangeShare(shard),
+		}
+		if r := shard.HashKeyRange; r != nil {
+			info.StartingHashKey = aws.StringValue(r.StartingHashKey)
+			info.EndingHashKey = aws.StringValue(r.EndingHashKey)
+		}
+		if r := shard.SequenceNumberRange; r != nil {
+			info.StartingSequenceNumber = aws.StringValue(r.StartingSequenceNumber)
+			info.EndingSequenceNumber = aws.StringValue(r.EndingSequenceNumber)
+		}
+		info.Open = info.EndingSequenceNumber == ""
+		infos[i] = info
+		byId[info.ShardId] = info
+	}
+	for _, info := range infos {
+		for _, parentId := range []string{info.ParentShardId, info.AdjacentParentShardId} {
+			if parent, ok := byId[parentId]; ok {
+				parent.ChildShardIds = append(parent.ChildShardIds, info.ShardId)
+			}
+		}
+	}
+	return infos
+}
+
+// streamTags reads all tags of a stream, a page at a time.
+func streamTags(svc *kinesis.Kinesis, streamName string) (map[string]string, error) {
+	tags := make(map[string]string)
+	input := &kinesis.ListTagsForStreamInput{StreamName: &streamName}
+	for {
+		out, err := svc.ListTagsForStream(input)
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range out.Tags {
+			tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+		if !aws.BoolValue(out.HasMoreTags) || len(out.Tags) == 0 {
+			return tags, nil
+		}
+		input.ExclusiveStartTagKey = out.Tags[len(out.Tags)-1].Key
+	}
+}
+
+func formatTags(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for key, value := range tags {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
+
+func (info *streamInfo) writeTable(w io.Writer) {
+	open := 0
+	for _, shard := range info.Shards {
+		if shard.Open {
+			open++
+		}
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Stream:\t%s\n", info.StreamName)
+	fmt.Fprintf(tw, "ARN:\t%s\n", info.StreamARN)
+	fmt.Fprintf(tw, "Status:\t%s\n", info.StreamStatus)
+	fmt.Fprintf(tw, "Retention:\t%dh\n", info.RetentionPeriodHours)
+	fmt.Fprintf(tw, "Tags:\t%s\n", formatTags(info.Tags))
+	fmt.Fprintf(tw, "Shards:\t%d open, %d closed\n", open, len(info.Shards)-open)
+	tw.Flush()
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SHARD\tSTATE\tPARENTS\tCHILDREN\tHASH KEYS\tSTARTING HASH KEY\tENDING HASH KEY\tSTARTING SEQUENCE NUMBER\tENDING SEQUENCE NUMBER")
+	for _, shard := range info.Shards {
+		state := "closed"
+		if shard.Open {
+			state = "open"
+		}
+		var parents []string
+		for _, parentId := range []string{shard.ParentShardId, shard.AdjacentParentShardId} {
+			if parentId != "" {
+				parents = append(parents, parentId)
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%.2f%%\t%s\t%s\t%s\t%s\n", shard.ShardId, state, dashIfEmpty(strings.Join(parents, ",")),
+			dashIfEmpty(strings.Join(shard.ChildShardIds, ",")), shard.HashKeyShare*100, shard.StartingHashKey, shard.EndingHashKey,
+			shard.StartingSequenceNumber, dashIfEmpty(shard.EndingSequenceNumber))
+	}
+	tw.Flush()
+}
+
+func dashIfEmpty(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+const defaultRetention = 24 * time.Hour
+
+// streamPollInterval is how often DescribeStream is called while waiting for
+// a stream to change status.
+var streamPollInterval = 2 * time.Second
+
+func isResourceNotFound(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == "ResourceNotFoundException"
+}
+
+// waitForStream polls the stream until its status is status, or until it no
+// longer exists when status is empty.
+func waitForStream(svc *kinesis.Kinesis, streamName, status string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		out, err := svc.DescribeStream(&kinesis.DescribeStreamInput{StreamName: &streamName, Limit: aws.Int64(1)})
+		if status == "" && isResourceNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		current := aws.StringValue(out.StreamDescription.StreamStatus)
+		if current == status {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("stream %s is still %s after %s", streamName, current, timeout)
+		}
+		time.Sleep(streamPollInterval)
+	}
+}
+
+// parseTags turns key=value pairs into a tag map.
+func parseTags(pairs []string) (map[string]string, error) {
+	tags := make(map[string]string)
+	for _, pair := range pairs {
+		i := strings.Index(pair, "=")
+		if i < 1 {
+			return nil, fmt.Errorf("tag %q is not key=value", pair)
+		}
+		tags[pair[:i]] = pair[i+1:]
+	}
+	return tags, nil
+}
+
+// maxTagsPerCall is how many tags AddTagsToStream accepts at once.
+const maxTagsPerCall = 10
+
+func addTags(svc *kinesis.Kinesis, streamName string, tags map[string]string) error {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > maxTagsPerCall {
+			n = maxTagsPerCall
+		}
+		batch := make(map[string]*string)
+		for _, key := range keys[:n] {
+			batch[key] = aws.String(tags[key])
+		}
+		if _, err := svc.AddTagsToStream(&kinesis.AddTagsToStreamInput{StreamName: &streamName, Tags: batch}); err != nil {
+			return err
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
+
+// retentionHours converts a retention period to the whole hours Kinesis
+// expects.
+func retentionHours(retention time.Duration) (int64, error) {
+	if retention < defaultRetention || retention%time.Hour != 0 {
+		return 0, fmt.Errorf("retention must be a whole number of hours, at least 24h, got %s", retention)
+	}
+	return int64(retention / time.Hour), nil
+}
+
+func runStreamsCreate(cmd *command, args []string) {
+	fs := cmd.flagSet()
+	var opts Options
+	var shards int
+	var retention, timeout time.Duration
+	var tagPairs stringList
+	var wait bool
+	addStreamFlags(fs, &opts)
+	fs.IntVar(&shards, "shards", 1, "Number of shards")
+	fs.DurationVar(&retention, "retention", defaultRetention, "How long records are kept, in whole hours")
+	fs.Var(&tagPairs, "tag", "Tag to add to the stream as key=value (repeatable)")
+	fs.BoolVar(&wait, "wait", false, "Wait until the stream is ACTIVE")
+	fs.DurationVar(&timeout, "timeout", 10*time.Minute, "Longest to wait for the stream")
+	fs.Parse(args)
+	requireStreamName(opts)
+	if shards < 1 {
+		log.Fatal("shards must be positive")
+	}
+	hours, err := retentionHours(retention)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tags, err := parseTags(tagPairs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	svc := createService(opts)
+	_, err = svc.CreateStream(&kinesis.CreateStreamInput{StreamName: &opts.StreamName, ShardCount: aws.Int64(int64(shards))})
+	if err != nil {
+		log.Fatal("Could not create stream: ", err)
+	}
+	log.Printf("Creating stream %s with %d shards", opts.StreamName, shards)
+	// Retention and tags can only be changed once the stream is ACTIVE.
+	if !wait && hours == 24 && len(tags) == 0 {
+		return
+	}
+	if err := waitForStream(svc, opts.StreamName, kinesis.StreamStatusActive, timeout); err != nil {
+		log.Fatal(err)
+	}
+	if hours > 24 {
+		_, err := svc.IncreaseStreamRetentionPeriod(&kinesis.IncreaseStreamRetentionPeriodInput{StreamName: &opts.StreamName, RetentionPeriodHours: &hours})
+		if err != nil {
+			log.Fatal("Could not set retention period: ", err)
+		}
+		if err := waitForStream(svc, opts.StreamName, kinesis.StreamStatusActive, timeout); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := addTags(svc, opts.StreamName, tags); err != nil {
+		log.Fatal("Could not tag stream: ", err)
+	}
+	log.Printf("Stream %s is ACTIVE", opts.StreamName)
+}
+
+func runStreamsDelete(cmd *command, args []string) {
+	fs := cmd.flagSet()
+	var opts Options
+	var timeout time.Duration
+	var wait bool
+	addStreamFlags(fs, &opts)
+	fs.BoolVar(&wait, "wait", false, "Wait until the stream is gone")
+	fs.DurationVar(&timeout, "timeout", 10*time.Minute, "Longest to wait for the stream")
+	fs.Parse(args)
+	requireStreamName(opts)
+	svc := createService(opts)
+	if _, err := svc.DeleteStream(&kinesis.DeleteStreamInput{StreamName: &opts.StreamName}); err != nil {
+		log.Fatal("Could not delete stream: ", err)
+	}
+	log.Printf("Deleting stream %s", opts.StreamName)
+	if !wait {
+		return
+	}
+	if err := waitForStream(svc, opts.StreamName, "", timeout); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Stream %s is deleted", opts.StreamName)
+}
+
+// setRetention changes the retention period of a stream to hours, using the
+// call that matches the direction of the change. It reports the previous
+// retention period.
+func setRetention(svc *kinesis.Kinesis, streamName string, hours int64) (int64, error) {
+	out, err := svc.DescribeStream(&kinesis.DescribeStreamInput{StreamName: &streamName, Limit: aws.Int64(1)})
+	if err != nil {
+		return 0, err
+	}
+	current := aws.Int64Value(out.StreamDescription.RetentionPeriodHours)
+	switch {
+	case hours > current:
+		_, err = svc.IncreaseStreamRetentionPeriod(&kinesis.IncreaseStreamRetentionPeriodInput{StreamName: &streamName, RetentionPeriodHours: &hours})
+	case hours < current:
+		_, err = svc.DecreaseStreamRetentionPeriod(&kinesis.DecreaseStreamRetentionPeriodInput{StreamName: &streamName, RetentionPeriodHours: &hours})
+	}
+	return current, err
+}
+
+func runStreamsRetention(cmd *command, args []string) {
+	fs := cmd.flagSet()
+	var opts Options
+	addStreamFlags(fs, &opts)
+	fs.Parse(args)
+	requireStreamName(opts)
+	if fs.NArg() != 1 {
+		log.Fatal("retention requires the new retention period, e.g. 168h")
+	}
+	retention, err := time.ParseDuration(fs.Arg(0))
+	if err != nil {
+		log.Fatal("Invalid retention period given ", fs.Arg(0))
+	}
+	hours, err := retentionHours(retention)
+	if err != nil {
+		log.Fatal(err)
+	}
+	previous, err := setRetention(createService(opts), opts.StreamName, hours)
+	if err != nil {
+		log.Fatal("Could not change retention period: ", err)
+	}
+	if previous == hours {
+		log.Printf("Stream %s already keeps records for %dh", opts.StreamName, hours)
+		return
+	}
+	log.Printf("Changed retention period of %s from %dh to %dh", opts.StreamName, previous, hours)
+}
+
+func removeTags(svc *kinesis.Kinesis, streamName string, keys []string) error {
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > maxTagsPerCall {
+			n = maxTagsPerCall
+		}
+		_, err := svc.RemoveTagsFromStream(&kinesis.RemoveTagsFromStreamInput{StreamName: &streamName, TagKeys: aws.StringSlice(keys[:n])})
+		if err != nil {
+			return err
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
+
+func runStreamsTag(cmd *command, args []string) {
+	fs := cmd.flagSet()
+	var opts Options
+	addStreamFlags(fs, &opts)
+	fs.Parse(args)
+	requireStreamName(opts)
+	if fs.NArg() == 0 {
+		log.Fatal("tag requires at least one key=value pair")
+	}
+	tags, err := parseTags(fs.Args())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := addTags(createService(opts), opts.StreamName, tags); err != nil {
+		log.Fatal("Could not tag stream: ", err)
+	}
+}
+
+func runStreamsUntag(cmd *command, args []string) {
+	fs := cmd.flagSet()
+	var opts Options
+	addStreamFlags(fs, &opts)
+	fs.Parse(args)
+	requireStreamName(opts)
+	if fs.NArg() == 0 {
+		log.Fatal("untag requires at least one tag key")
+	}
+	if err := removeTags(createService(opts), opts.StreamName, fs.Args()); err != nil {
+		log.Fatal("Could not untag stream: ", err)
+	}
+}
+
+func runStreamsTags(cmd *command, args []string) {
+	fs := cmd.flagSet()
+	var opts Options
+	var output string
+	addStreamFlags(fs, &opts)
+	addOutputFlag(fs, &output)
+	fs.Parse(args)
+	requireStreamName(opts)
+	checkOutput(output)
+	tags, err := streamTags(createService(opts), opts.StreamName)
+	if err != nil {
+		log.Fatal("Could not list tags: ", err)
+	}
+	if output == jsonOutput {
+		writeJSON(os.Stdout, tags)
+		return
+	}
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tVALUE")
+	for _, key := range keys {
+		fmt.Fprintf(tw, "%s\t%s\n", key, tags[key])
+	}
+	tw.Flush()
+}
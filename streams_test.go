@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/kinesis"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withSequenceNumbers gives shard a sequence number range, closed when
+// endSeq is set.
+func withSequenceNumbers(shard *kinesis.Shard, endSeq string) *kinesis.Shard {
+	shard.SequenceNumberRange = &kinesis.SequenceNumberRange{StartingSequenceNumber: aws.String("100")}
+	if endSeq != "" {
+		shard.SequenceNumberRange.EndingSequenceNumber = aws.String(endSeq)
+	}
+	return shard
+}
+
+func TestShardInfosLineage(t *testing.T) {
+	infos := shardInfos([]*kinesis.Shard{
+		withSequenceNumbers(testShard("shardId-000000000000", "", "", "0", maxHashKey), "200"),
+		withSequenceNumbers(testShard("shardId-000000000001", "shardId-000000000000", "", "0", "170141183460469231731687303715884105727"), "300"),
+		withSequenceNumbers(testShard("shardId-000000000002", "shardId-000000000000", "", halfHashKey, maxHashKey), "300"),
+		withSequenceNumbers(testShard("shardId-000000000003", "shardId-000000000001", "shardId-000000000002", "0", maxHashKey), ""),
+	})
+	if !reflect.DeepEqual(infos[0].ChildShardIds, []string{"shardId-000000000001", "shardId-000000000002"}) {
+		t.Errorf("Unexpected children %v", infos[0].ChildShardIds)
+	}
+	if !reflect.DeepEqual(infos[2].ChildShardIds, []string{"shardId-000000000003"}) {
+		t.Errorf("Unexpected children %v", infos[2].ChildShardIds)
+	}
+	if infos[0].Open || !infos[3].Open {
+		t.Error("Expected only the last shard to be open")
+	}
+	if infos[1].HashKeyShare != 0.5 || infos[3].HashKeyShare != 1 {
+		t.Errorf("Unexpected shares %f %f", infos[1].HashKeyShare, infos[3].HashKeyShare)
+	}
+}
+
+func TestStreamInfoTable(t *testing.T) {
+	info := &streamInfo{
+		StreamName:           "clicks",
+		StreamStatus:         "ACTIVE",
+		RetentionPeriodHours: 48,
+		Tags:                 map[string]string{"team": "web", "env": "prod"},
+		Shards: shardInfos([]*kinesis.Shard{
+			withSequenceNumbers(testShard("shardId-000000000000", "", "", "0", maxHashKey), "200"),
+			withSequenceNumbers(testShard("shardId-000000000001", "shardId-000000000000", "", "0", maxHashKey), ""),
+		}),
+	}
+	var buf bytes.Buffer
+	info.writeTable(&buf)
+	out := buf.String()
+	for _, want := range []string{"Retention:  48h", "Tags:       env=prod, team=web", "Shards:     1 open, 1 closed"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in\n%s", want, out)
+		}
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	last := strings.Fields(lines[len(lines)-1])
+	if !reflect.DeepEqual(last[:5], []string{"shardId-000000000001", "open", "shardId-000000000000", "-", "100.00%"}) {
+		t.Errorf("Unexpected shard row %v", last)
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tags, err := parseTags([]string{"env=prod", "owner=a=b", "empty="})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tags, map[string]string{"env": "prod", "owner": "a=b", "empty": ""}) {
+		t.Errorf("Unexpected tags %v", tags)
+	}
+	for _, bad := range []string{"env", "=prod"} {
+		if _, err := parseTags([]string{bad}); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
+
+func TestRetentionHours(t *testing.T) {
+	if hours, err := retentionHours(7 * 24 * time.Hour); err != nil || hours != 168 {
+		t.Errorf("Expected 168 hours, got %d %v", hours, err)
+	}
+	for _, bad := range []time.Duration{12 * time.Hour, 36*time.Hour + time.Minute} {
+		if _, err := retentionHours(bad); err == nil {
+			t.Errorf("Expected an error for %s", bad)
+		}
+	}
+}
+
+func TestWaitForStream(t *testing.T) {
+	defer func(d time.Duration) { streamPollInterval = d }(streamPollInterval)
+	streamPollInterval = time.Millisecond
+	calls := 0
+	statuses := []string{"CREATING", "CREATING", "ACTIVE", "DELETING", "gone"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := statuses[calls]
+		calls++
+		if status == "gone" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type":"ResourceNotFoundException","message":"Stream test not found"}`))
+			return
+		}
+		w.Write([]byte(`{"StreamDescription":{"StreamName":"test","StreamStatus":"` + status + `","HasMoreShards":false,"Shards":[]}}`))
+	}))
+	defer server.Close()
+
+	svc := newTestService(server.URL)
+	if err := waitForStream(svc, "test", kinesis.StreamStatusActive, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 calls until ACTIVE, got %d", calls)
+	}
+	if err := waitForStream(svc, "test", "", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 5 {
+		t.Errorf("Expected 5 calls until deleted, got %d", calls)
+	}
+}
+
+func TestSetRetention(t *testing.T) {
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "Kinesis_20131202.")
+		calls = append(calls, target)
+		if target == "DescribeStream" {
+			w.Write([]byte(`{"StreamDescription":{"StreamName":"test","StreamStatus":"ACTIVE","RetentionPeriodHours":48,"HasMoreShards":false,"Shards":[]}}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	svc := newTestService(server.URL)
+	tests := []struct {
+		hours int64
+		call  string
+	}{
+		{168, "IncreaseStreamRetentionPeriod"},
+		{24, "DecreaseStreamRetentionPeriod"},
+		{48, ""},
+	}
+	for _, test := range tests {
+		calls = nil
+		previous, err := setRetention(svc, "test", test.hours)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if previous != 48 {
+			t.Errorf("Expected previous retention 48, got %d", previous)
+		}
+		want := []string{"DescribeStream"}
+		if test.call != "" {
+			want = append(want, test.call)
+		}
+		if !reflect.DeepEqual(calls, want) {
+			t.Errorf("Setting %dh made calls %v, expected %v", test.hours, calls, want)
+		}
+	}
+}
+
+func TestTagPagination(t *testing.T) {
+	var removed [][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var in struct {
+			ExclusiveStartTagKey string
+			TagKeys              []string
+		}
+		json.Unmarshal(body, &in)
+		switch r.Header.Get("X-Amz-Target") {
+		case "Kinesis_20131202.ListTagsForStream":
+			if in.ExclusiveStartTagKey == "" {
+				w.Write([]byte(`{"HasMoreTags":true,"Tags":[{"Key":"a","Value":"1"},{"Key":"b","Value":"2"}]}`))
+			} else if in.ExclusiveStartTagKey == "b" {
+				w.Write([]byte(`{"HasMoreTags":false,"Tags":[{"Key":"c","Value":""}]}`))
+			} else {
+				t.Errorf("Unexpected start key %q", in.ExclusiveStartTagKey)
+			}
+		case "Kinesis_20131202.RemoveTagsFromStream":
+			removed = append(removed, in.TagKeys)
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+
+	svc := newTestService(server.URL)
+	tags, err := streamTags(svc, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tags, map[string]string{"a": "1", "b": "2", "c": ""}) {
+		t.Errorf("Unexpected tags %v", tags)
+	}
+	var keys []string
+	for i := 0; i < 12; i++ {
+		keys = append(keys, fmt.Sprintf("key%d", i))
+	}
+	if err := removeTags(svc, "test", keys); err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 2 || len(removed[0]) != 10 || len(removed[1]) != 2 {
+		t.Errorf("Expected tags removed 10 then 2 at a time, got %v", removed)
+	}
+}
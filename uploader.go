@@ -1,6 +1,8 @@
 package main
 
 import (
+	"crypto/md5"
+	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/firehose"
 	"github.com/aws/aws-sdk-go/service/kinesis"
@@ -64,9 +66,26 @@ func pack(src []byte, dest *[]byte) bool {
 
 }
 
+const (
+	randomPartitionKey = "random"
+	hashPartitionKey   = "hash"
+)
+
 func createRecord() *kinesis.PutRecordsRequestEntry {
-	uid := uuid.NewV4().String()
-	return &kinesis.PutRecordsRequestEntry{PartitionKey: &uid}
+	return &kinesis.PutRecordsRequestEntry{}
+}
+
+// partitionKey returns the key to put data with: a new UUID for random, the
+// MD5 of data for hash, so that equal records share a shard, or else the
+// given key itself.
+func partitionKey(strategy string, data []byte) string {
+	switch strategy {
+	case randomPartitionKey:
+		return uuid.NewV4().String()
+	case hashPartitionKey:
+		return fmt.Sprintf("%x", md5.Sum(data))
+	}
+	return strategy
 }
 
 func (upldr *uploader) Upload(data []byte) {
@@ -81,6 +100,8 @@ func (upldr *uploader) Upload(data []byte) {
 		for i := 0; i < len(upldr.records); i++ {
 			upldr.records[i] = nil
 		}
+		// The line that did not fit starts the next batch.
+		upldr.Upload(data)
 	} else if full {
 		upldr.position++
 		record := createRecord()
@@ -91,7 +112,7 @@ func (upldr *uploader) Upload(data []byte) {
 
 func (fupldr *firehoseUploader) Upload(data []byte) {
 	if fupldr.records[fupldr.position] == nil {
-		fupldr.records[fupldr.position] = &firehose.Record{Data: data}
+		fupldr.records[fupldr.position] = &firehose.Record{}
 	}
 	full := pack(data, &fupldr.records[fupldr.position].Data)
 	if full && fupldr.position == MaxPutIdx {
@@ -100,8 +121,12 @@ func (fupldr *firehoseUploader) Upload(data []byte) {
 		for i := 0; i < len(fupldr.records); i++ {
 			fupldr.records[i] = nil
 		}
-	} else {
+		fupldr.Upload(data)
+	} else if full {
 		fupldr.position++
+		record := &firehose.Record{}
+		fupldr.records[fupldr.position] = record
+		pack(data, &record.Data)
 	}
 }
 
@@ -143,6 +168,9 @@ func (upldr *uploader) shipAndCheck() {
 	} else {
 		records = upldr.records[:upldr.position+1]
 	}
+	for _, record := range records {
+		record.PartitionKey = aws.String(partitionKey(upldr.opts.PartitionKey, record.Data))
+	}
 	putRecordsInput := &kinesis.PutRecordsInput{Records: records, StreamName: &upldr.opts.StreamName}
 	putRecordsOutput, err := upldr.svc.PutRecords(putRecordsInput)
 	if err != nil {
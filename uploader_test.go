@@ -2,8 +2,17 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/firehose"
 	"github.com/aws/aws-sdk-go/service/kinesis"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 	"testing/quick"
 )
@@ -59,3 +68,88 @@ func TestRecords(t *testing.T) {
 	x := make([]*kinesis.PutRecordsRequestEntry, 10)
 	fmt.Printf("%s", x)
 }
+
+// newPutServer answers PutRecords and PutRecordBatch calls, recording the
+// data of every record put.
+func newPutServer(t *testing.T, records *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var in struct{ Records []struct{ Data []byte } }
+		if err := json.Unmarshal(body, &in); err != nil {
+			t.Error(err)
+		}
+		results := make([]map[string]string, len(in.Records))
+		for i, record := range in.Records {
+			*records = append(*records, string(record.Data))
+			results[i] = map[string]string{"SequenceNumber": "1", "ShardId": "shardId-000000000000", "RecordId": "1"}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"FailedRecordCount": 0, "Records": results, "FailedPutCount": 0, "RequestResponses": results})
+	}))
+}
+
+func newTestFirehoseService(url string) *firehose.Firehose {
+	return firehose.New(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(url),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	})
+}
+
+func TestUploadKeepsLinesThatDoNotFit(t *testing.T) {
+	big := strings.Repeat("x", MAX_PAYLOAD*3/5)
+	lines := []string{"a" + big, "b" + big, "c", "d" + big}
+	for _, firehoseMode := range []bool{false, true} {
+		var records []string
+		server := newPutServer(t, &records)
+		upldr := NewUploader(newTestService(server.URL), newTestFirehoseService(server.URL), Options{StreamName: "s", PartitionKey: randomPartitionKey, Firehose: firehoseMode})
+		for _, line := range lines {
+			upldr.Upload([]byte(line))
+		}
+		upldr.Flush()
+		server.Close()
+		expected := []string{lines[0], lines[1] + "\nc", lines[3]}
+		if !reflect.DeepEqual(records, expected) {
+			t.Errorf("Firehose %v: expected %d records starting %v, got %d", firehoseMode, len(expected), prefixes(expected), len(records))
+		}
+	}
+}
+
+func TestUploadKeepsLineThatDoesNotFitFullBatch(t *testing.T) {
+	var records []string
+	server := newPutServer(t, &records)
+	defer server.Close()
+	upldr := NewUploader(newTestService(server.URL), nil, Options{StreamName: "s", PartitionKey: randomPartitionKey}).(*uploader)
+	for i := range upldr.records {
+		upldr.records[i] = &kinesis.PutRecordsRequestEntry{PartitionKey: aws.String("k"), Data: []byte{'.'}}
+	}
+	upldr.records[MaxPutIdx].Data = bytes.Repeat([]byte{'.'}, MAX_PAYLOAD-1)
+	upldr.position = MaxPutIdx
+	upldr.Upload([]byte("overflow"))
+	upldr.Flush()
+	if len(records) != MaxPutIdx+2 || records[len(records)-1] != "overflow" {
+		t.Errorf("Expected a full batch and then the overflowing line, got %d records", len(records))
+	}
+}
+
+func prefixes(records []string) []string {
+	var out []string
+	for _, record := range records {
+		if len(record) > 3 {
+			record = record[:3]
+		}
+		out = append(out, record)
+	}
+	return out
+}
+
+func TestPartitionKey(t *testing.T) {
+	if a, b := partitionKey(randomPartitionKey, []byte("x")), partitionKey(randomPartitionKey, []byte("x")); a == b {
+		t.Errorf("Expected random keys to differ, got %s twice", a)
+	}
+	if key := partitionKey(hashPartitionKey, []byte("x")); key != "9dd4e461268c8034f5c8564e155c67a6" {
+		t.Errorf("Expected the MD5 of the data, got %s", key)
+	}
+	if key := partitionKey("user-1", []byte("x")); key != "user-1" {
+		t.Errorf("Expected the fixed key, got %s", key)
+	}
+}